@@ -0,0 +1,47 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// These describe the build of sqlcc. Release builds set them with:
+//
+//	go build -ldflags "-X main.buildVersion=v1.2.3 -X main.buildCommit=abc123"
+//
+// When they are not set, they are taken from the information the Go toolchain
+// embeds in the binary, if any.
+var (
+	buildVersion string
+	buildCommit  string
+)
+
+// buildInfo returns the version of sqlcc, the commit it was built from, and the
+// version of Go it was built with. The version and commit are "unknown" if
+// they cannot be determined.
+func buildInfo() (version, commit, goVersion string) {
+	version, commit = buildVersion, buildCommit
+	if info, ok := debug.ReadBuildInfo(); ok {
+		// binaries built by "go build" in a checkout have version "(devel)",
+		// which says nothing useful
+		if version == "" && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+
+		for _, setting := range info.Settings {
+			if commit == "" && setting.Key == "vcs.revision" {
+				commit = setting.Value
+			}
+		}
+	}
+
+	if version == "" {
+		version = "unknown"
+	}
+
+	if commit == "" {
+		commit = "unknown"
+	}
+
+	return version, commit, runtime.Version()
+}
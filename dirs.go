@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// migrationDirs returns the directories given in --migrations, which is a
+// comma-separated list.
+func (a rootArgs) migrationDirs() []string {
+	var dirs []string
+	for _, dir := range strings.Split(a.Migrations, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// migrationsFS returns the migrations in --migrations. If more than one
+// directory was given, their contents are merged together.
+func (a rootArgs) migrationsFS() fs.FS {
+	dirs := a.migrationDirs()
+	if len(dirs) == 1 {
+		return os.DirFS(dirs[0])
+	}
+
+	return mergedDirFS(dirs)
+}
+
+// mergedDirFS is a fs.FS whose contents are the contents of each of its
+// directories, merged together. No file may be in more than one of the
+// directories, so that it is unambiguous which directory a file comes from.
+type mergedDirFS []string
+
+func (f mergedDirFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	for _, dir := range f {
+		file, err := os.DirFS(dir).Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		return file, err
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (f mergedDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	dirsByName := map[string]string{}
+	for _, dir := range f {
+		dirEntries, err := fs.ReadDir(os.DirFS(dir), name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range dirEntries {
+			if entry.IsDir() {
+				continue
+			}
+
+			if other, ok := dirsByName[entry.Name()]; ok {
+				return nil, fmt.Errorf("%q is in more than one migrations directory: %s, %s", entry.Name(), other, dir)
+			}
+
+			dirsByName[entry.Name()] = dir
+			entries = append(entries, entry)
+		}
+	}
+
+	// fs.ReadDirFS requires entries to be sorted by name
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
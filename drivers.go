@@ -1,7 +1,22 @@
 package main
 
 import (
+	_ "github.com/ClickHouse/clickhouse-go/v2"
+	_ "github.com/denisenkom/go-mssqldb"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	_ "modernc.org/sqlite"
 )
+
+// sqlDriverName returns the name of the database/sql driver to use for driver.
+// CockroachDB speaks the Postgres wire protocol, so it uses the Postgres
+// driver. "sqlite3" and "sqlite" are the names the cgo and pure-Go SQLite
+// drivers register themselves under, so they are used as-is.
+func sqlDriverName(driver string) string {
+	if driver == "cockroachdb" {
+		return "postgres"
+	}
+
+	return driver
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// hasDSNParts returns whether any of the flags that a DSN can be composed from
+// were provided.
+func (a rootArgs) hasDSNParts() bool {
+	return a.Host != "" || a.Port != 0 || a.User != "" || a.Password != "" || a.DBName != "" || a.SSLMode != ""
+}
+
+// composeDSN returns a DSN for -D/--driver, composed from --host, --port,
+// --user, --password, --dbname, and --sslmode. Each driver has a DSN syntax of
+// its own; see the documentation for -d/--dsn.
+func (a rootArgs) composeDSN() (string, error) {
+	if a.Port > 65535 {
+		return "", usageErrorf("invalid --port: must be at most 65535")
+	}
+
+	if a.SSLMode != "" && a.Driver != "postgres" && a.Driver != "cockroachdb" {
+		return "", usageErrorf("--sslmode is only supported for postgres and cockroachdb; put TLS settings in -d/--dsn instead")
+	}
+
+	// a SQLite database is just a file
+	if a.Driver == "sqlite3" || a.Driver == "sqlite" {
+		if a.Host != "" || a.Port != 0 || a.User != "" || a.Password != "" {
+			return "", usageErrorf("--host, --port, --user, and --password are not supported for %s; use --dbname for the database file", a.Driver)
+		}
+
+		return a.DBName, nil
+	}
+
+	if a.Host == "" {
+		return "", usageErrorf("--host is required to compose a DSN for %s, if -d/--dsn is not provided", a.Driver)
+	}
+
+	host := a.Host
+	if a.Port != 0 {
+		host = net.JoinHostPort(a.Host, strconv.FormatUint(uint64(a.Port), 10))
+	} else if strings.Contains(host, ":") && a.Driver != "mysql" {
+		// an IPv6 address must be bracketed in a URL
+		host = "[" + host + "]"
+	}
+
+	if a.Driver == "mysql" {
+		config := mysql.NewConfig()
+		config.User = a.User
+		config.Passwd = a.Password
+		config.Net = "tcp"
+		config.Addr = host
+		config.DBName = a.DBName
+
+		// without this, migrations with more than one statement fail
+		config.MultiStatements = true
+		return config.FormatDSN(), nil
+	}
+
+	u := url.URL{Host: host}
+	if a.User != "" || a.Password != "" {
+		u.User = url.UserPassword(a.User, a.Password)
+	}
+
+	query := url.Values{}
+	switch a.Driver {
+	case "postgres", "cockroachdb":
+		u.Scheme = "postgres"
+		u.Path = "/" + a.DBName
+		if a.SSLMode != "" {
+			query.Set("sslmode", a.SSLMode)
+		}
+	case "sqlserver":
+		u.Scheme = "sqlserver"
+		if a.DBName != "" {
+			query.Set("database", a.DBName)
+		}
+	case "clickhouse":
+		u.Scheme = "clickhouse"
+		u.Path = "/" + a.DBName
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
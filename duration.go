@@ -0,0 +1,17 @@
+package main
+
+import "time"
+
+// duration is a time.Duration that can be parsed from command-line arguments
+// like "30s" or "5m", using time.ParseDuration.
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = duration(parsed)
+	return nil
+}
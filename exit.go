@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ucarion/sqlcc/migrator"
+)
+
+// These are the exit codes of sqlcc. They are part of sqlcc's interface, so
+// that scripts can tell failures apart; do not renumber them.
+const (
+	// exitError is the exit code for any error not covered by another code.
+	exitError = 1
+
+	// exitUsage is the exit code for invalid arguments.
+	exitUsage = 2
+
+	// exitDirty is the exit code when the state is dirty.
+	exitDirty = 3
+
+	// exitNotInitialized is the exit code when the state table does not exist.
+	exitNotInitialized = 4
+
+	// exitLocked is the exit code when another sqlcc process holds the lock on
+	// the state table.
+	exitLocked = 5
+
+	// exitPending is the exit code when "sqlcc migrate --check" finds
+	// migrations that have not been applied.
+	exitPending = 6
+
+	// exitChecksumMismatch is the exit code when an applied migration has been
+	// modified since it was applied.
+	exitChecksumMismatch = 7
+)
+
+// exitCode returns the exit code for err, which must be non-nil.
+func exitCode(err error) int {
+	var usageErr usageError
+	switch {
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.Is(err, migrator.ErrDirty):
+		return exitDirty
+	case errors.Is(err, migrator.ErrNotInitialized):
+		return exitNotInitialized
+	case errors.Is(err, migrator.ErrLocked):
+		return exitLocked
+	case errors.Is(err, errPending):
+		return exitPending
+	case errors.Is(err, migrator.ErrChecksumMismatch):
+		return exitChecksumMismatch
+	default:
+		return exitError
+	}
+}
+
+// withExitCode wraps f, the command named name, or the root command if name is
+// empty, so that it exits with the exit code for the error it returns.
+//
+// cli.Run always exits with exitError, so for any other exit code the error is
+// output and sqlcc exits here instead, in the same format cli.Run uses. Errors
+// parsing the command line are reported by cli.Run before f is called, and so
+// exit with exitError rather than exitUsage.
+//
+// Errors may include the DSN, such as when a driver fails to parse it, so
+// passwords are redacted from them before they are output.
+func withExitCode[T any](name string, f func(context.Context, T) error) func(context.Context, T) error {
+	return func(ctx context.Context, args T) error {
+		err := f(ctx, args)
+		if err == nil {
+			return nil
+		}
+
+		err = redactedError{err: err}
+
+		if code := exitCode(err); code != exitError {
+			// the root command has no name of its own
+			if name == "" {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s %s: %v\n", os.Args[0], name, err)
+			}
+
+			os.Exit(code)
+		}
+
+		return err
+	}
+}
+
+// errPending is returned, wrapped, by "sqlcc migrate --check" when there are
+// migrations that have not been applied.
+var errPending = errors.New("migrations are pending")
+
+// usageError is an error caused by invalid arguments.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e usageError) Unwrap() error {
+	return e.err
+}
+
+// usageErrorf is like fmt.Errorf, but returns a usageError.
+func usageErrorf(format string, args ...any) error {
+	return usageError{err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,152 @@
+package migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// checksum returns the hex-encoded SHA-256 of the contents of a migration file.
+func checksum(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// These are the statements that create the checksums table if it does not
+// already exist, for each driver. The checksums table holds the checksum of
+// each migration that has been run, keyed by version.
+const (
+	initChecksumsSQL           = `create table if not exists %s (version bigint not null, checksum char(64) not null)`
+	initChecksumsSQLMySQL      = `create table if not exists %s (version bigint not null, checksum char(64) not null)`
+	initChecksumsSQLSQLServer  = `if object_id('%s', 'U') is null create table %s (version bigint not null, checksum char(64) not null)`
+	initChecksumsSQLClickHouse = `create table if not exists %s (version Int64, checksum String) engine = MergeTree order by version`
+)
+
+const checksumsSQL = `select version, checksum from %s`
+const insertChecksumSQL = `insert into %s values (?, ?)`
+const deleteChecksumSQL = `delete from %s where version = ?`
+
+// ClickHouse deletes rows using mutations.
+const deleteChecksumSQLClickHouse = `alter table %s delete where version = ?`
+
+// checksumTable returns the name of the checksums table, which is derived from
+// the name of the state table.
+func (m *Migrator) checksumTable() string {
+	return m.qualify(m.StateTable + "_checksums")
+}
+
+// initChecksums creates the checksums table, if it does not already exist.
+//
+// This is idempotent so that databases whose state table was created before
+// sqlcc kept checksums get a checksums table as soon as it is needed.
+func (m *Migrator) initChecksums(ctx context.Context, q queryer) error {
+	var query string
+	switch m.Driver {
+	case "mysql":
+		query = fmt.Sprintf(initChecksumsSQLMySQL, m.checksumTable())
+	case "sqlserver":
+		query = fmt.Sprintf(initChecksumsSQLSQLServer, m.checksumTable(), m.checksumTable())
+	case "clickhouse":
+		query = fmt.Sprintf(initChecksumsSQLClickHouse, m.checksumTable())
+	default:
+		query = fmt.Sprintf(initChecksumsSQL, m.checksumTable())
+	}
+
+	if _, err := q.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("create checksums table: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Migrator) getChecksums(ctx context.Context, q queryer) (map[int64]string, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(checksumsSQL, m.checksumTable()))
+	if err != nil {
+		return nil, fmt.Errorf("read checksums from db: %w", err)
+	}
+
+	defer rows.Close()
+
+	checksums := map[int64]string{}
+	for rows.Next() {
+		var version int64
+		var sum string
+		if err := rows.Scan(&version, &sum); err != nil {
+			return nil, fmt.Errorf("read checksums from db: %w", err)
+		}
+
+		checksums[version] = sum
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read checksums from db: %w", err)
+	}
+
+	return checksums, nil
+}
+
+// setChecksum records the checksum of mig, replacing any existing checksum for
+// its version.
+func (m *Migrator) setChecksum(ctx context.Context, q queryer, mig migration) error {
+	if err := m.deleteChecksum(ctx, q, mig.version); err != nil {
+		return err
+	}
+
+	if _, err := q.ExecContext(ctx, rebind(m.Driver, fmt.Sprintf(insertChecksumSQL, m.checksumTable())), mig.version, mig.checksum); err != nil {
+		return fmt.Errorf("write checksum to db: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Migrator) deleteChecksum(ctx context.Context, q queryer, version int64) error {
+	query := deleteChecksumSQL
+	if m.Driver == "clickhouse" {
+		query = deleteChecksumSQLClickHouse
+	}
+
+	if _, err := q.ExecContext(ctx, rebind(m.Driver, fmt.Sprintf(query, m.checksumTable())), version); err != nil {
+		return fmt.Errorf("delete checksum from db: %w", err)
+	}
+
+	return nil
+}
+
+// ErrChecksumMismatch is returned, wrapped, when a migration that has already
+// been applied has been modified since, per its recorded checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// verifyChecksums checks that every migration at or before version whose
+// checksum was recorded still has the same checksum. Migrations run before
+// sqlcc recorded checksums have no recorded checksum, and are not checked.
+func (m *Migrator) verifyChecksums(ctx context.Context, q queryer, migrations []migration, version int64) error {
+	checksums, err := m.getChecksums(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	if modified := modifiedMigrations(migrations, checksums, version); len(modified) > 0 {
+		return fmt.Errorf("migration %q has been modified since it was applied: %w", modified[0].name, ErrChecksumMismatch)
+	}
+
+	return nil
+}
+
+// modifiedMigrations returns the migrations at or before version whose
+// checksum differs from their recorded checksum.
+func modifiedMigrations(migrations []migration, checksums map[int64]string, version int64) []migration {
+	var modified []migration
+	for _, mig := range migrations {
+		if mig.version > version {
+			break
+		}
+
+		if sum, ok := checksums[mig.version]; ok && sum != mig.checksum {
+			modified = append(modified, mig)
+		}
+	}
+
+	return modified
+}
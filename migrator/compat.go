@@ -0,0 +1,102 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+)
+
+// Compat is the layout and meaning of the state table a Migrator uses. Other
+// migration tools keep state much like sqlcc does; Compat lets a Migrator use
+// their state tables, so that a database can be switched to or from sqlcc
+// without being baselined again.
+type Compat int
+
+const (
+	// CompatNone uses sqlcc's own state table.
+	CompatNone Compat = iota
+
+	// CompatGolangMigrate uses a state table like the schema_migrations table
+	// of golang-migrate, which has only a version and a dirty column. It
+	// differs from sqlcc's in two ways:
+	//
+	// When no migrations have been run, the table is empty, rather than
+	// having a row at version 0.
+	//
+	// When a migration is being run, the table has the version the migration
+	// is moving to, rather than the version it is moving from. The dirty
+	// version alone does not say which migration failed, so
+	// MigrateOptions.AllowDirty is not supported; use Reset instead.
+	CompatGolangMigrate
+)
+
+// GolangMigrateStateTable is the name golang-migrate gives its state table,
+// unless it is configured otherwise.
+const GolangMigrateStateTable = "schema_migrations"
+
+// These are the statements that create a state table like golang-migrate's, for
+// each driver. Like the statements that create sqlcc's state table, they take
+// the name of the state table, and of its version and dirty columns.
+const (
+	initSQLGolangMigrate          = `create table %s (%s bigint not null primary key, %s boolean not null)`
+	initSQLGolangMigrateSQLite    = `create table %s (%s integer not null primary key, %s boolean not null)`
+	initSQLGolangMigrateSQLServer = `create table %s (%s bigint not null primary key, %s bit not null)`
+)
+
+// clearStateSQL deletes the state table's row, which golang-migrate does before
+// inserting a new one.
+const clearStateSQL = `delete from %s`
+
+// ValidateCompat checks that compat can be used with driver.
+func ValidateCompat(driver string, compat Compat) error {
+	// golang-migrate's state table on ClickHouse is a log of every version,
+	// unlike on the other databases
+	if compat == CompatGolangMigrate && driver == "clickhouse" {
+		return fmt.Errorf("golang-migrate compatibility is not supported for clickhouse")
+	}
+
+	return nil
+}
+
+// dirtyState returns the state to record while a migration named name is run,
+// taking the state from s to version. sqlcc records s's version, so that if the
+// migration fails, the state says which migrations were run completely.
+// golang-migrate records version.
+func (m *Migrator) dirtyState(s State, version int64, name string) State {
+	if m.Compat == CompatGolangMigrate {
+		return State{Version: version, Dirty: true, DirtyMigration: name}
+	}
+
+	return State{Version: s.Version, Dirty: true, DirtyMigration: name}
+}
+
+// writeGolangMigrateState writes s to a state table like golang-migrate's, only
+// if the state is still prev if prev is non-nil. As golang-migrate does, it
+// deletes the state table's row, and then inserts a new one unless s is at
+// version 0 and clean.
+func (m *Migrator) writeGolangMigrateState(ctx context.Context, q queryer, columns stateColumns, prev *State, s State) error {
+	if prev != nil {
+		current, err := m.getState(ctx, q)
+		if err != nil {
+			return fmt.Errorf("write state to db: %w", err)
+		}
+
+		if current.Version != prev.Version || current.Dirty != prev.Dirty {
+			return fmt.Errorf("%w: expected version %d (dirty: %t)", ErrStateChanged, prev.Version, prev.Dirty)
+		}
+	}
+
+	if _, err := q.ExecContext(ctx, fmt.Sprintf(clearStateSQL, m.stateTable())); err != nil {
+		return fmt.Errorf("write state to db: %w", err)
+	}
+
+	if s.Version == 0 && !s.Dirty {
+		return nil
+	}
+
+	query := fmt.Sprintf("insert into %s (%s, %s) values (?, ?)", m.stateTable(), columns.version, columns.dirty)
+	if _, err := q.ExecContext(ctx, rebind(m.Driver, query), s.Version, s.Dirty); err != nil {
+		return fmt.Errorf("write state to db: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// withTx runs f against db, in a transaction if inTx is set. Statements f runs,
+// and the beginning and end of the transaction, are logged to log.
+//
+// If inTx is not set, f is still run against a single connection, so that
+// session state, such as the search path, carries over between its statements.
+func withTx(ctx context.Context, log debugLog, inTx bool, db *sql.DB, f func(queryer) error) error {
+	if !inTx {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("get conn: %w", err)
+		}
+
+		defer conn.Close()
+		return f(log.wrap(conn))
+	}
+
+	log.printf("begin tx")
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	if err := f(log.wrap(tx)); err != nil {
+		log.printf("rollback tx")
+
+		// if ctx was canceled, database/sql has already rolled back the
+		// transaction, and err describes why better than ErrTxDone would
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			return fmt.Errorf("rollback tx: %w", err)
+		}
+
+		return err
+	}
+
+	log.printf("commit tx")
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// withTxRetries is like withTx with inTx set, except that if f or committing
+// fails with an error that retryable reports as retryable, then withTxRetries
+// re-runs f in a new transaction, up to a total of attempts times.
+func withTxRetries(ctx context.Context, log debugLog, attempts int, retryable func(error) bool, db *sql.DB, f func(queryer) error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		err = withTx(ctx, log, true, db, f)
+		if err == nil || !retryable(err) {
+			return err
+		}
+
+		log.printf("retrying tx after error: %v", err)
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}
@@ -0,0 +1,91 @@
+package migrator
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// drivers are the values Migrator.Driver may have.
+var drivers = []string{"mysql", "postgres", "sqlite3", "sqlite", "sqlserver", "cockroachdb", "clickhouse"}
+
+// sameDatabase returns whether drivers a and b are for the same kind of
+// database. "sqlite3" and "sqlite" are different drivers for SQLite.
+func sameDatabase(a, b string) bool {
+	isSQLite := func(driver string) bool { return driver == "sqlite3" || driver == "sqlite" }
+	return a == b || (isSQLite(a) && isSQLite(b))
+}
+
+// rebind rewrites a query using "?" placeholders into the placeholder style
+// of driver. Postgres and CockroachDB use "$1", "$2", etc.; SQL Server uses
+// "@p1", "@p2", etc.; the other drivers use "?" as-is.
+//
+// rebind does not understand SQL quoting, so it must only be used on queries
+// that do not contain a literal "?".
+func rebind(driver, query string) string {
+	var prefix string
+	switch driver {
+	case "postgres", "cockroachdb":
+		prefix = "$"
+	case "sqlserver":
+		prefix = "@p"
+	default:
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+
+		n++
+		b.WriteString(prefix)
+		b.WriteString(strconv.Itoa(n))
+	}
+
+	return b.String()
+}
+
+// isUndefinedTableError reports whether err indicates that a query referred to
+// a table that does not exist.
+//
+// Only the Postgres driver is inspected by type, because it is already
+// imported for isRetryableError. The other drivers are recognized by their
+// error numbers as they appear in error messages, so that this package does
+// not force its users to link in every driver, including cgo ones.
+func isUndefinedTableError(driver string, err error) bool {
+	switch driver {
+	case "postgres", "cockroachdb":
+		var pqErr *pq.Error
+		return errors.As(err, &pqErr) && pqErr.Code == "42P01"
+	case "mysql":
+		// ER_NO_SUCH_TABLE
+		return strings.Contains(err.Error(), "Error 1146")
+	case "sqlite3", "sqlite":
+		return strings.Contains(err.Error(), "no such table")
+	case "sqlserver":
+		// "Invalid object name"
+		var sqlErr interface{ SQLErrorNumber() int32 }
+		return errors.As(err, &sqlErr) && sqlErr.SQLErrorNumber() == 208
+	case "clickhouse":
+		// UNKNOWN_TABLE
+		return strings.Contains(err.Error(), "code: 60,")
+	default:
+		return false
+	}
+}
+
+// isRetryableError reports whether err indicates that a transaction should be
+// retried from the start.
+//
+// CockroachDB aborts transactions that conflict with one another with SQLSTATE
+// 40001 (serialization_failure), and expects clients to retry them.
+func isRetryableError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
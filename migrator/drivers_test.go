@@ -0,0 +1,73 @@
+package migrator
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	query := "update t set a = ?, b = ? where c = ? and d = ? and e = ? and f = ? and g = ? and h = ? and i = ? and j = ?"
+
+	for _, tt := range []struct {
+		driver string
+		want   string
+	}{
+		{"postgres", "update t set a = $1, b = $2 where c = $3 and d = $4 and e = $5 and f = $6 and g = $7 and h = $8 and i = $9 and j = $10"},
+		{"cockroachdb", "update t set a = $1, b = $2 where c = $3 and d = $4 and e = $5 and f = $6 and g = $7 and h = $8 and i = $9 and j = $10"},
+		{"sqlserver", "update t set a = @p1, b = @p2 where c = @p3 and d = @p4 and e = @p5 and f = @p6 and g = @p7 and h = @p8 and i = @p9 and j = @p10"},
+		{"mysql", query},
+		{"sqlite3", query},
+	} {
+		t.Run(tt.driver, func(t *testing.T) {
+			if got := rebind(tt.driver, query); got != tt.want {
+				t.Errorf("rebind(%q):\ngot:  %s\nwant: %s", tt.driver, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateTableDDL(t *testing.T) {
+	for _, tt := range []struct {
+		driver     string
+		wantCreate string
+		wantSeed   string
+	}{
+		{
+			"postgres",
+			"create table sqlcc_state (version bigint not null, dirty boolean not null, applied_at timestamp null, dirty_migration varchar(255) null)",
+			"insert into sqlcc_state (version, dirty) values ($1, $2)",
+		},
+		{
+			"cockroachdb",
+			"create table sqlcc_state (version bigint not null, dirty boolean not null, applied_at timestamp null, dirty_migration varchar(255) null)",
+			"insert into sqlcc_state (version, dirty) values ($1, $2)",
+		},
+		{
+			"mysql",
+			"create table sqlcc_state (version bigint not null, dirty tinyint(1) not null, applied_at datetime(6) null, dirty_migration varchar(255) null)",
+			"insert into sqlcc_state (version, dirty) values (?, ?)",
+		},
+		{
+			"sqlite3",
+			"create table sqlcc_state (version integer not null, dirty boolean not null, applied_at timestamp null, dirty_migration varchar(255) null)",
+			"insert into sqlcc_state (version, dirty) values (?, ?)",
+		},
+		{
+			"sqlserver",
+			"create table sqlcc_state (version bigint not null, dirty bit not null, applied_at datetime2 null, dirty_migration nvarchar(255) null)",
+			"insert into sqlcc_state (version, dirty) values (@p1, @p2)",
+		},
+	} {
+		t.Run(tt.driver, func(t *testing.T) {
+			createSQL, seedSQL := stateTableDDL(tt.driver, CompatNone)
+
+			if got := fmt.Sprintf(createSQL, "sqlcc_state", "version", "dirty"); got != tt.wantCreate {
+				t.Errorf("create:\ngot:  %s\nwant: %s", got, tt.wantCreate)
+			}
+
+			if got := rebind(tt.driver, fmt.Sprintf(seedSQL, "sqlcc_state", "version", "dirty")); got != tt.wantSeed {
+				t.Errorf("seed:\ngot:  %s\nwant: %s", got, tt.wantSeed)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package migrator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MigrationFormat is the format migration files are written in.
+type MigrationFormat int
+
+const (
+	// FormatSQLCC is sqlcc's own format. A migration file is entirely its up
+	// half, unless it has a "-- +down" line, after which is its down half. A
+	// migration's down half may instead be in a file of its own.
+	FormatSQLCC MigrationFormat = iota
+
+	// FormatGoose is the format of Goose, another migration tool. A migration
+	// file's up half follows a "-- +goose Up" line, and its down half follows
+	// a "-- +goose Down" line. Lines between "-- +goose StatementBegin" and
+	// "-- +goose StatementEnd" are a single statement, even if they contain
+	// semicolons, when statements are run separately. A
+	// "-- +goose NO TRANSACTION" line is like a "-- sqlcc:no-transaction"
+	// directive.
+	FormatGoose
+)
+
+// gooseAnnotationPattern matches a line with a Goose annotation, like
+// "-- +goose Up". Its capture group is the annotation.
+var gooseAnnotationPattern = regexp.MustCompile(`^--\s*\+goose\s+(.*?)\s*$`)
+
+// gooseAnnotation returns the Goose annotation on line, lowercased, or the empty
+// string if line has none.
+func gooseAnnotation(line string) string {
+	match := gooseAnnotationPattern.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return ""
+	}
+
+	return strings.ToLower(match[1])
+}
+
+// loadGooseQuery populates mig's queries, hasDown, and noTx from query, the
+// contents of mig's file in FormatGoose. Like Goose, it ignores comments before
+// the "-- +goose Up" line, including ones that span lines, but nothing else.
+func (mig *migration) loadGooseQuery(query string) error {
+	const (
+		sectionNone = iota
+		sectionUp
+		sectionDown
+	)
+
+	var header, up, down strings.Builder
+	section := sectionNone
+	var inStatement bool
+	for i, line := range strings.SplitAfter(query, "\n") {
+		annotation := gooseAnnotation(line)
+		switch annotation {
+		case "":
+			switch section {
+			case sectionNone:
+				header.WriteString(line)
+			case sectionUp:
+				up.WriteString(line)
+			case sectionDown:
+				down.WriteString(line)
+			}
+
+			continue
+		case "up":
+			if section != sectionNone {
+				return fmt.Errorf("migration %q has more than one \"-- +goose Up\" line, or one after \"-- +goose Down\", on line %d", mig.name, i+1)
+			}
+
+			// the header is checked as a whole, because its comments may span
+			// lines
+			if len(splitStatements("", header.String())) > 0 {
+				return fmt.Errorf("migration %q has SQL before its \"-- +goose Up\" line, which is on line %d", mig.name, i+1)
+			}
+
+			section = sectionUp
+			continue
+		case "down":
+			if section != sectionUp || inStatement {
+				return fmt.Errorf("migration %q has an unexpected \"-- +goose Down\" line, on line %d", mig.name, i+1)
+			}
+
+			section = sectionDown
+			mig.hasDown = true
+			continue
+		case "statementbegin":
+			if section == sectionNone || inStatement {
+				return fmt.Errorf("migration %q has an unexpected \"-- +goose StatementBegin\" line, on line %d", mig.name, i+1)
+			}
+
+			inStatement = true
+		case "statementend":
+			if !inStatement {
+				return fmt.Errorf("migration %q has an unexpected \"-- +goose StatementEnd\" line, on line %d", mig.name, i+1)
+			}
+
+			inStatement = false
+		case "no transaction":
+			mig.noTx = true
+			continue
+		default:
+			return fmt.Errorf("migration %q has an unsupported Goose annotation, on line %d: %q", mig.name, i+1, strings.TrimSpace(line))
+		}
+
+		// statement annotations are kept, so that the statements can be split
+		// apart again when they are run
+		if section == sectionUp {
+			up.WriteString(line)
+		} else {
+			down.WriteString(line)
+		}
+	}
+
+	if section == sectionNone {
+		return fmt.Errorf("migration %q has no \"-- +goose Up\" line", mig.name)
+	}
+
+	if inStatement {
+		return fmt.Errorf("migration %q has a \"-- +goose StatementBegin\" line without a matching \"-- +goose StatementEnd\"", mig.name)
+	}
+
+	mig.upQuery, mig.downQuery = up.String(), down.String()
+	return nil
+}
+
+// splitGooseStatements is like splitStatements, except that query is half of a
+// migration in FormatGoose, and so the lines between each
+// "-- +goose StatementBegin" and "-- +goose StatementEnd" are a single
+// statement.
+func splitGooseStatements(driver, query string) []string {
+	var statements []string
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(query, "\n") {
+		switch gooseAnnotation(line) {
+		case "statementbegin":
+			statements = append(statements, splitStatements(driver, b.String())...)
+			b.Reset()
+		case "statementend":
+			if statement := strings.TrimSpace(b.String()); statement != "" {
+				statements = append(statements, statement)
+			}
+
+			b.Reset()
+		default:
+			b.WriteString(line)
+		}
+	}
+
+	return append(statements, splitStatements(driver, b.String())...)
+}
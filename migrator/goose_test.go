@@ -0,0 +1,150 @@
+package migrator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadGooseQuery(t *testing.T) {
+	query := `/*
+ * Creates the users table; see
+ * the design doc.
+ */
+-- +goose Up
+-- +goose NO TRANSACTION
+create table users (id bigint primary key);
+-- +goose StatementBegin
+create function f() returns trigger as $$
+begin
+  new.id := 1;
+  return new;
+end;
+$$ language plpgsql;
+-- +goose StatementEnd
+create index users_id on users (id);
+
+-- +goose Down
+drop table users;
+`
+
+	mig := migration{name: "1_users.sql"}
+	if err := mig.loadGooseQuery(query); err != nil {
+		t.Fatal(err)
+	}
+
+	if !mig.hasDown || !mig.noTx {
+		t.Errorf("got hasDown %t, noTx %t, want both to be true", mig.hasDown, mig.noTx)
+	}
+
+	if got, want := strings.TrimSpace(mig.downQuery), "drop table users;"; got != want {
+		t.Errorf("got down query %q, want %q", got, want)
+	}
+
+	want := []string{
+		"create table users (id bigint primary key)",
+		"create function f() returns trigger as $$\nbegin\n  new.id := 1;\n  return new;\nend;\n$$ language plpgsql;",
+		"create index users_id on users (id)",
+	}
+
+	// sqlite does not understand dollar quotes, so the semicolons inside the
+	// function are only protected by StatementBegin and StatementEnd
+	if got := splitGooseStatements("sqlite3", mig.upQuery); !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestLoadGooseQueryErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			"sql before up",
+			"create table a (x int);\n-- +goose Up\nselect 1;\n",
+			`migration "1_a.sql" has SQL before its "-- +goose Up" line, which is on line 2`,
+		},
+		{
+			"sql after a multi-line comment before up",
+			"/* a\n b */ select 1;\n-- +goose Up\nselect 1;\n",
+			`migration "1_a.sql" has SQL before its "-- +goose Up" line, which is on line 3`,
+		},
+		{
+			"no up",
+			"-- a comment\n",
+			`migration "1_a.sql" has no "-- +goose Up" line`,
+		},
+		{
+			"duplicate up",
+			"-- +goose Up\nselect 1;\n-- +goose Up\nselect 2;\n",
+			`migration "1_a.sql" has more than one "-- +goose Up" line, or one after "-- +goose Down", on line 3`,
+		},
+		{
+			"up after down",
+			"-- +goose Up\nselect 1;\n-- +goose Down\nselect 2;\n-- +goose Up\n",
+			`migration "1_a.sql" has more than one "-- +goose Up" line, or one after "-- +goose Down", on line 5`,
+		},
+		{
+			"down before up",
+			"-- +goose Down\nselect 1;\n",
+			`migration "1_a.sql" has an unexpected "-- +goose Down" line, on line 1`,
+		},
+		{
+			"down inside statement",
+			"-- +goose Up\n-- +goose StatementBegin\nselect 1;\n-- +goose Down\n-- +goose StatementEnd\n",
+			`migration "1_a.sql" has an unexpected "-- +goose Down" line, on line 4`,
+		},
+		{
+			"statement begin before up",
+			"-- +goose StatementBegin\nselect 1;\n-- +goose StatementEnd\n",
+			`migration "1_a.sql" has an unexpected "-- +goose StatementBegin" line, on line 1`,
+		},
+		{
+			"nested statement begin",
+			"-- +goose Up\n-- +goose StatementBegin\n-- +goose StatementBegin\n",
+			`migration "1_a.sql" has an unexpected "-- +goose StatementBegin" line, on line 3`,
+		},
+		{
+			"unterminated statement begin",
+			"-- +goose Up\n-- +goose StatementBegin\nselect 1;\n",
+			`migration "1_a.sql" has a "-- +goose StatementBegin" line without a matching "-- +goose StatementEnd"`,
+		},
+		{
+			"statement end without begin",
+			"-- +goose Up\nselect 1;\n-- +goose StatementEnd\n",
+			`migration "1_a.sql" has an unexpected "-- +goose StatementEnd" line, on line 3`,
+		},
+		{
+			"unknown annotation",
+			"-- +goose Up\n-- +goose ENVSUB ON\nselect 1;\n",
+			`migration "1_a.sql" has an unsupported Goose annotation, on line 2: "-- +goose ENVSUB ON"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mig := migration{name: "1_a.sql"}
+			err := mig.loadGooseQuery(tt.query)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+
+			if err.Error() != tt.want {
+				t.Errorf("got error:\n%s\nwant:\n%s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadGooseQueryCommentsBeforeUp(t *testing.T) {
+	for _, query := range []string{
+		"-- +goose Up\nselect 1;\n",
+		"-- a comment\n\n-- +goose Up\nselect 1;\n",
+		"/*\n * a comment\n * across lines; with a semicolon\n */\n-- +goose Up\nselect 1;\n",
+		"/* one */ /* two\n */\n-- +goose Up\nselect 1;\n",
+	} {
+		mig := migration{name: "1_a.sql"}
+		if err := mig.loadGooseQuery(query); err != nil {
+			t.Errorf("loadGooseQuery(%q): %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// These are the statements that create the history table if it does not
+// already exist, for each driver. Unlike the state table, which holds only the
+// current version, the history table gets a row appended every time a
+// migration is run.
+const (
+	initHistorySQL           = `create table if not exists %s (version bigint not null, name varchar(255) not null, applied_at timestamp not null, duration_ms bigint not null)`
+	initHistorySQLMySQL      = `create table if not exists %s (version bigint not null, name varchar(255) not null, applied_at datetime(6) not null, duration_ms bigint not null)`
+	initHistorySQLSQLServer  = `if object_id('%s', 'U') is null create table %s (version bigint not null, name nvarchar(255) not null, applied_at datetime2 not null, duration_ms bigint not null)`
+	initHistorySQLClickHouse = `create table if not exists %s (version Int64, name String, applied_at DateTime64(6), duration_ms Int64) engine = MergeTree order by applied_at`
+)
+
+const historySQL = `select version, name, applied_at, duration_ms from %s order by applied_at desc`
+const insertHistorySQL = `insert into %s values (?, ?, ?, ?)`
+
+// HistoryEntry is a record of a migration having been run.
+type HistoryEntry struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+	Duration  time.Duration
+}
+
+// historyTable returns the name of the history table, which is derived from the
+// name of the state table.
+func (m *Migrator) historyTable() string {
+	return m.qualify(m.StateTable + "_history")
+}
+
+// initHistory creates the history table, if it does not already exist.
+//
+// Like initChecksums, this is idempotent so that databases whose state table
+// was created before sqlcc kept history get a history table as soon as it is
+// needed.
+func (m *Migrator) initHistory(ctx context.Context, q queryer) error {
+	var query string
+	switch m.Driver {
+	case "mysql":
+		query = fmt.Sprintf(initHistorySQLMySQL, m.historyTable())
+	case "sqlserver":
+		query = fmt.Sprintf(initHistorySQLSQLServer, m.historyTable(), m.historyTable())
+	case "clickhouse":
+		query = fmt.Sprintf(initHistorySQLClickHouse, m.historyTable())
+	default:
+		query = fmt.Sprintf(initHistorySQL, m.historyTable())
+	}
+
+	if _, err := q.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("create history table: %w", err)
+	}
+
+	return nil
+}
+
+// History returns the most recently run migrations, most recent first. If limit
+// is positive, at most limit entries are returned.
+func (m *Migrator) History(ctx context.Context, limit int) ([]HistoryEntry, error) {
+	var history []HistoryEntry
+	err := m.withTx(ctx, func(q queryer) error {
+		if err := m.initHistory(ctx, q); err != nil {
+			return err
+		}
+
+		var err error
+		history, err = m.getHistory(ctx, q, limit)
+		return err
+	})
+
+	return history, err
+}
+
+func (m *Migrator) getHistory(ctx context.Context, q queryer, limit int) ([]HistoryEntry, error) {
+	query := fmt.Sprintf(historySQL, m.historyTable())
+	if limit > 0 {
+		if m.Driver == "sqlserver" {
+			query = fmt.Sprintf(`select top %d version, name, applied_at, duration_ms from %s order by applied_at desc`, limit, m.historyTable())
+		} else {
+			query = fmt.Sprintf("%s limit %d", query, limit)
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("read history from db: %w", err)
+	}
+
+	defer rows.Close()
+
+	var history []HistoryEntry
+	for rows.Next() {
+		var h HistoryEntry
+		var appliedAt timestamp
+		var durationMS int64
+		if err := rows.Scan(&h.Version, &h.Name, &appliedAt, &durationMS); err != nil {
+			return nil, fmt.Errorf("read history from db: %w", err)
+		}
+
+		h.AppliedAt = time.Time(appliedAt)
+		h.Duration = time.Duration(durationMS) * time.Millisecond
+		history = append(history, h)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read history from db: %w", err)
+	}
+
+	return history, nil
+}
+
+// insertHistory records that mig was run at appliedAt, and took duration.
+func (m *Migrator) insertHistory(ctx context.Context, q queryer, mig migration, appliedAt time.Time, duration time.Duration) error {
+	query := rebind(m.Driver, fmt.Sprintf(insertHistorySQL, m.historyTable()))
+	if _, err := q.ExecContext(ctx, query, mig.version, mig.name, appliedAt.UTC(), duration.Milliseconds()); err != nil {
+		return fmt.Errorf("write history to db: %w", err)
+	}
+
+	return nil
+}
+
+// timestamp scans a time from the database. Null is scanned as the zero time.
+//
+// The MySQL driver returns times as text unless the DSN contains
+// parseTime=true, so timestamp also accepts text in the formats drivers use.
+type timestamp time.Time
+
+func (t *timestamp) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*t = timestamp{}
+		return nil
+	case time.Time:
+		*t = timestamp(src)
+		return nil
+	case []byte:
+		return t.parse(string(src))
+	case string:
+		return t.parse(src)
+	default:
+		return fmt.Errorf("cannot scan %T into timestamp", src)
+	}
+}
+
+func (t *timestamp) parse(s string) error {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			*t = timestamp(parsed)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot parse %q as timestamp", s)
+}
@@ -0,0 +1,148 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// InitSQL returns the statements that Init would run on a database without a
+// state table, so that they can be run by hand, such as by a DBA in an
+// environment where sqlcc may not create tables. Unlike Init, InitSQL does not
+// use m.DB, and the statements it returns have their parameters written out as
+// literals.
+//
+// Once the statements have been run, m can be used as if Init had been.
+func (m *Migrator) InitSQL(opts InitOptions) ([]string, error) {
+	var migrations []migration
+	if opts.Baseline != 0 {
+		var err error
+		migrations, err = parseMigrations(m.Migrations, m.parseOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		if !hasMigration(migrations, opts.Baseline) {
+			return nil, fmt.Errorf("no migration with baseline version: %d", opts.Baseline)
+		}
+	}
+
+	ctx := context.Background()
+	q := &recordingQueryer{driver: m.Driver}
+	if err := m.initState(ctx, q, opts.Baseline); err != nil {
+		return nil, err
+	}
+
+	if err := m.initChecksums(ctx, q); err != nil {
+		return nil, err
+	}
+
+	if err := m.setBaselineChecksums(ctx, q, migrations, opts.Baseline); err != nil {
+		return nil, err
+	}
+
+	if err := m.initHistory(ctx, q); err != nil {
+		return nil, err
+	}
+
+	return q.statements, nil
+}
+
+// recordingQueryer is a queryer that, instead of running statements, records
+// them with their parameters written out as literals. It only supports
+// ExecContext.
+type recordingQueryer struct {
+	driver     string
+	statements []string
+}
+
+func (q *recordingQueryer) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := inlineArgs(q.driver, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	q.statements = append(q.statements, stmt)
+	return recordedResult{}, nil
+}
+
+func (q *recordingQueryer) QueryContext(context.Context, string, ...any) (*sql.Rows, error) {
+	return nil, fmt.Errorf("cannot query while recording statements")
+}
+
+func (q *recordingQueryer) QueryRowContext(context.Context, string, ...any) *sql.Row {
+	// sql.Row cannot be constructed with an error outside of database/sql
+	panic("cannot query while recording statements")
+}
+
+// recordedResult is the sql.Result of a statement that was recorded rather than
+// run.
+type recordedResult struct{}
+
+func (recordedResult) LastInsertId() (int64, error) { return 0, nil }
+func (recordedResult) RowsAffected() (int64, error) { return 0, nil }
+
+// inlineArgs replaces the placeholders in query, which is in the placeholder
+// style of driver, with args written as literals. Like rebind, it does not
+// understand SQL quoting.
+func inlineArgs(driver, query string, args []any) (string, error) {
+	literals := make([]string, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case int64:
+			literals[i] = strconv.FormatInt(arg, 10)
+		case bool:
+			literals[i] = boolLiteral(driver, arg)
+		case string:
+			literals[i] = "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+		default:
+			return "", fmt.Errorf("cannot write %T as a literal", arg)
+		}
+	}
+
+	var prefix string
+	switch driver {
+	case "postgres", "cockroachdb":
+		prefix = "$"
+	case "sqlserver":
+		prefix = "@p"
+	default:
+		var b strings.Builder
+		n := 0
+		for _, r := range query {
+			if r == '?' && n < len(literals) {
+				b.WriteString(literals[n])
+				n++
+				continue
+			}
+
+			b.WriteRune(r)
+		}
+
+		return b.String(), nil
+	}
+
+	// in reverse, so that "$1" does not match the start of "$10"
+	for i := len(literals) - 1; i >= 0; i-- {
+		query = strings.ReplaceAll(query, prefix+strconv.Itoa(i+1), literals[i])
+	}
+
+	return query, nil
+}
+
+// boolLiteral returns b as a literal in the syntax of driver. SQLite and SQL
+// Server have no boolean literals, so 0 and 1 are used instead.
+func boolLiteral(driver string, b bool) string {
+	switch driver {
+	case "sqlite3", "sqlite", "sqlserver":
+		if b {
+			return "1"
+		}
+
+		return "0"
+	default:
+		return strconv.FormatBool(b)
+	}
+}
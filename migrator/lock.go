@@ -0,0 +1,248 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"time"
+)
+
+// These are the statements that acquire and release an advisory lock, for each
+// driver that supports one. Postgres advisory locks are keyed by an integer;
+// MySQL named locks are keyed by a string.
+const (
+	// pg_advisory_lock has no timeout, so instead pg_try_advisory_lock is
+	// polled until the lock is acquired or the timeout elapses.
+	lockSQLPostgres   = `select pg_try_advisory_lock(?)`
+	unlockSQLPostgres = `select pg_advisory_unlock(?)`
+
+	// get_lock takes a timeout in seconds.
+	lockSQLMySQL   = `select get_lock(?, ?)`
+	unlockSQLMySQL = `select release_lock(?)`
+)
+
+// These are the statements that find the database session holding the lock,
+// and end that session, for each driver that takes a lock. Postgres splits
+// 64-bit advisory lock keys across the classid and objid columns of pg_locks.
+const (
+	lockHolderSQLPostgres = `select pid from pg_locks where locktype = 'advisory' and database = (select oid from pg_database where datname = current_database()) and classid = ? and objid = ? and objsubid = 1 and granted`
+	killSQLPostgres       = `select pg_terminate_backend(?)`
+
+	lockHolderSQLMySQL = `select is_used_lock(?)`
+	killSQLMySQL       = `kill %d`
+)
+
+// ErrLocked is returned, wrapped, when the lock on the state table cannot be
+// acquired because another sqlcc process holds it.
+var ErrLocked = errors.New("another migration is in progress")
+
+// defaultLockTimeout is the lock timeout used if LockTimeout is zero.
+const defaultLockTimeout = time.Minute
+
+// lockPollInterval is how often Postgres is polled while waiting for a lock.
+const lockPollInterval = 500 * time.Millisecond
+
+// lockKey returns the key of the advisory lock for the state table. Every sqlcc
+// process using the same state table uses the same key, whether its schema was
+// given in StateSchema or as part of StateTable.
+func (m *Migrator) lockKey() int64 {
+	name := m.StateTable
+	if m.StateSchema != "" {
+		name = m.StateSchema + "." + m.StateTable
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// lockName is like lockKey, but for databases whose locks are keyed by name.
+func (m *Migrator) lockName() string {
+	return fmt.Sprintf("sqlcc_%016x", uint64(m.lockKey()))
+}
+
+// withLock runs f while holding an advisory lock on the state table, so that
+// concurrent sqlcc processes cannot interleave their changes to the same
+// database. If the lock cannot be acquired within m.LockTimeout, withLock
+// returns an error without running f.
+//
+// The lock is held on a dedicated connection, separate from the ones f uses.
+// Only Postgres and MySQL take a lock. SQLite already serializes writers using
+// its file lock, CockroachDB accepts but ignores advisory locks, and the other
+// databases are not locked at all.
+func (m *Migrator) withLock(ctx context.Context, f func() error) (err error) {
+	var unlockSQL string
+	var key any
+	switch m.Driver {
+	case "postgres":
+		unlockSQL, key = unlockSQLPostgres, m.lockKey()
+	case "mysql":
+		unlockSQL, key = unlockSQLMySQL, m.lockName()
+	default:
+		return f()
+	}
+
+	timeout := m.LockTimeout
+	if timeout == 0 {
+		timeout = defaultLockTimeout
+	}
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire lock: %w", err)
+	}
+
+	defer conn.Close()
+
+	var acquired bool
+	switch m.Driver {
+	case "postgres":
+		acquired, err = pollLock(ctx, conn, rebind(m.Driver, lockSQLPostgres), key, timeout)
+	case "mysql":
+		seconds := int(math.Ceil(timeout.Seconds()))
+		acquired, err = tryLock(ctx, conn, lockSQLMySQL, key, seconds)
+	}
+
+	if err != nil {
+		return fmt.Errorf("acquire lock: %w", err)
+	}
+
+	if !acquired {
+		return fmt.Errorf("%w: could not acquire lock within %s", ErrLocked, timeout)
+	}
+
+	m.debugLog().printf("acquired lock %v", key)
+
+	defer func() {
+		// release the lock even if ctx has been canceled
+		released, unlockErr := tryLock(context.Background(), conn, rebind(m.Driver, unlockSQL), key)
+		m.debugLog().printf("released lock %v", key)
+		if unlockErr == nil && !released {
+			unlockErr = fmt.Errorf("lock not held")
+		}
+
+		if unlockErr != nil && err == nil {
+			err = fmt.Errorf("release lock: %w", unlockErr)
+		}
+	}()
+
+	return f()
+}
+
+// pollLock runs query, a statement that tries to acquire a lock without
+// waiting, until it succeeds or timeout elapses.
+func pollLock(ctx context.Context, conn *sql.Conn, query string, key any, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		acquired, err := tryLock(ctx, conn, query, key)
+		if err != nil || acquired {
+			return acquired, err
+		}
+
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// tryLock runs a statement that acquires or releases a lock, and returns
+// whether it succeeded. Such statements return true or 1 on success, and false,
+// 0, or null otherwise.
+func tryLock(ctx context.Context, conn *sql.Conn, query string, args ...any) (bool, error) {
+	var ok sql.NullBool
+	if err := conn.QueryRowContext(ctx, query, args...).Scan(&ok); err != nil {
+		return false, err
+	}
+
+	return ok.Bool, nil
+}
+
+// UnlockOptions are options for Unlock.
+type UnlockOptions struct {
+	// DryRun, if true, only finds the holder of the lock, without releasing
+	// it.
+	DryRun bool
+}
+
+// LockStatus describes the lock on the state table.
+type LockStatus struct {
+	// Key identifies the lock. On Postgres, it is the advisory lock's key, in
+	// decimal. On MySQL, it is the lock's name.
+	Key string
+
+	// Held is whether a database session holds the lock.
+	Held bool
+
+	// Session is, if Held, the ID of the session holding the lock: the pid of
+	// its backend on Postgres, and its connection ID on MySQL.
+	Session int64
+}
+
+// Unlock forcibly releases the lock on the state table, as taken by Migrate
+// and the other operations that change the state table. A lock can only be
+// released by the database session that holds it, so Unlock instead ends that
+// session. This undoes anything the session was doing and has not committed.
+//
+// Unlock returns the status of the lock before it was released. Unlock does
+// nothing if the lock is not held. If the driver is not one that Migrator
+// takes a lock on, Unlock returns the zero LockStatus.
+func (m *Migrator) Unlock(ctx context.Context, opts UnlockOptions) (LockStatus, error) {
+	q := m.debugLog().wrap(m.DB)
+
+	var status LockStatus
+	var holder sql.NullInt64
+	var err error
+	switch m.Driver {
+	case "postgres":
+		key := m.lockKey()
+		status.Key = strconv.FormatInt(key, 10)
+		err = q.QueryRowContext(ctx, rebind(m.Driver, lockHolderSQLPostgres), int64(uint32(uint64(key)>>32)), int64(uint32(key))).Scan(&holder)
+	case "mysql":
+		status.Key = m.lockName()
+		err = q.QueryRowContext(ctx, lockHolderSQLMySQL, status.Key).Scan(&holder)
+	default:
+		return LockStatus{}, nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return status, nil
+	}
+
+	if err != nil {
+		return LockStatus{}, fmt.Errorf("find lock holder: %w", err)
+	}
+
+	status.Held, status.Session = holder.Valid, holder.Int64
+	if !status.Held || opts.DryRun {
+		return status, nil
+	}
+
+	switch m.Driver {
+	case "postgres":
+		var ok bool
+		if err := q.QueryRowContext(ctx, rebind(m.Driver, killSQLPostgres), status.Session).Scan(&ok); err != nil {
+			return status, fmt.Errorf("end lock holder's session: %w", err)
+		}
+
+		if !ok {
+			return status, fmt.Errorf("end lock holder's session: session %d could not be ended", status.Session)
+		}
+	case "mysql":
+		// kill does not accept placeholders, but the session ID is a number
+		if _, err := q.ExecContext(ctx, fmt.Sprintf(killSQLMySQL, status.Session)); err != nil {
+			return status, fmt.Errorf("end lock holder's session: %w", err)
+		}
+	}
+
+	return status, nil
+}
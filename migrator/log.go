@@ -0,0 +1,160 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Logger receives reports of a Migrator's progress.
+type Logger interface {
+	// Log is called as each migration is run, or would be run if e.DryRun is
+	// set.
+	Log(e Event)
+}
+
+// Event describes a migration being run.
+type Event struct {
+	// Migration is the migration being run.
+	Migration Migration
+
+	// Down is whether the migration is being rolled back, rather than applied.
+	Down bool
+
+	// Redo is whether the migration is being run as part of Redo.
+	Redo bool
+
+	// DryRun is whether the migration is only being reported, and not
+	// actually run.
+	DryRun bool
+
+	// Skipped is whether the migration has a "-- sqlcc:skip" directive, so
+	// that its SQL is not run, even though the version is advanced past it.
+	Skipped bool
+
+	// OutOfOrder is whether the migration is older than the current version,
+	// and is being run because Migrate's OutOfOrder option is set.
+	OutOfOrder bool
+
+	// Err, if non-nil, is the error the migration failed with. Failures are
+	// only reported when Migrate's ContinueOnError option is set, once the
+	// migration has been rolled back; otherwise Migrate returns the error.
+	Err error
+
+	// Position is the 1-based position of the migration among the Total
+	// migrations that Migrate is running. Both are zero for operations other
+	// than Migrate.
+	Position int
+	Total    int
+}
+
+// TextLogger is a Logger that writes each migration's name to Output, one per
+// line. When the migration is being applied by Migrate, its name is prefixed
+// with its position among the migrations being applied, like "[3/17] running".
+// When the migration is being run as part of Redo, its name is prefixed with
+// "up" or "down". Skipped migrations are written as "SKIPPING", failed
+// migrations as "FAILED", and migrations run out of order are followed by
+// "(OUT OF ORDER)", in capitals, so that they stand out.
+type TextLogger struct {
+	Output io.Writer
+}
+
+func (l TextLogger) Log(e Event) {
+	var position string
+	if e.Total > 0 && !e.DryRun {
+		position = fmt.Sprintf("[%d/%d] ", e.Position, e.Total)
+	}
+
+	if e.Err != nil {
+		fmt.Fprintf(l.Output, "%sFAILED %s: %v\n", position, e.Migration.Name, e.Err)
+		return
+	}
+
+	if e.Skipped {
+		fmt.Fprintf(l.Output, "%sSKIPPING %s: it has a \"-- sqlcc:skip\" directive, so its SQL is not run\n", position, e.Migration.Name)
+		return
+	}
+
+	switch {
+	case e.Total > 0 && !e.DryRun && e.OutOfOrder:
+		fmt.Fprintf(l.Output, "[%d/%d] running %s (OUT OF ORDER)\n", e.Position, e.Total, e.Migration.Name)
+	case e.Total > 0 && !e.DryRun:
+		fmt.Fprintf(l.Output, "[%d/%d] running %s\n", e.Position, e.Total, e.Migration.Name)
+	case e.Redo && e.Down:
+		fmt.Fprintln(l.Output, "down", e.Migration.Name)
+	case e.Redo:
+		fmt.Fprintln(l.Output, "up", e.Migration.Name)
+	default:
+		fmt.Fprintln(l.Output, e.Migration.Name)
+	}
+}
+
+// debugLog writes a description of everything a Migrator does to the database:
+// each statement it runs and how long the statement took, and when
+// transactions begin and end. The zero debugLog writes nothing.
+type debugLog struct {
+	w io.Writer
+}
+
+func (l debugLog) printf(format string, args ...any) {
+	if l.w == nil {
+		return
+	}
+
+	fmt.Fprintf(l.w, "sqlcc: "+format+"\n", args...)
+}
+
+// wrap returns a queryer that logs each statement run against q to l. If l
+// writes nothing, q is returned as-is.
+func (l debugLog) wrap(q queryer) queryer {
+	if l.w == nil {
+		return q
+	}
+
+	return loggedQueryer{q: q, log: l}
+}
+
+// statement logs that query was run with args, taking elapsed, and failed with
+// err if it is non-nil.
+func (l debugLog) statement(query string, args []any, elapsed time.Duration, err error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "run (%s): %s", elapsed.Round(time.Microsecond), strings.TrimSpace(query))
+	if len(args) > 0 {
+		fmt.Fprintf(&b, " with args %v", args)
+	}
+
+	if err != nil {
+		fmt.Fprintf(&b, ": error: %v", err)
+	}
+
+	l.printf("%s", b.String())
+}
+
+type loggedQueryer struct {
+	q   queryer
+	log debugLog
+}
+
+func (q loggedQueryer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	res, err := q.q.ExecContext(ctx, query, args...)
+	q.log.statement(query, args, time.Since(start), err)
+	return res, err
+}
+
+func (q loggedQueryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := q.q.QueryContext(ctx, query, args...)
+	q.log.statement(query, args, time.Since(start), err)
+	return rows, err
+}
+
+func (q loggedQueryer) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	start := time.Now()
+	row := q.q.QueryRowContext(ctx, query, args...)
+	q.log.statement(query, args, time.Since(start), row.Err())
+	return row
+}
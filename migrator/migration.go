@@ -0,0 +1,786 @@
+package migrator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode/utf8"
+)
+
+// Migration describes a migration file.
+type Migration struct {
+	Version int64
+	Name    string
+}
+
+// migration is a migration file, and its down migration file if it has one.
+//
+// Only version, name, and downName are populated when migrations are listed.
+// The rest are populated when the migration is loaded, which requires reading
+// its files.
+type migration struct {
+	version  int64
+	name     string
+	downName string
+
+	loaded    bool
+	upQuery   string
+	downQuery string
+	hasDown   bool
+	noTx      bool
+	skip      bool
+	checksum  string
+}
+
+// public returns the exported description of mig.
+func (mig migration) public() Migration {
+	return Migration{Version: mig.version, Name: mig.name}
+}
+
+// ValidateOptions are options for Validate.
+type ValidateOptions struct {
+	// Contiguous, if true, additionally requires that migration versions have
+	// no gaps between them.
+	Contiguous bool
+
+	// NamePattern is as in Migrator.
+	NamePattern *regexp.Regexp
+
+	// Extensions is as in Migrator.
+	Extensions []string
+
+	// ExpandEnv is as in Migrator.
+	ExpandEnv bool
+
+	// TemplateData is as in Migrator.
+	TemplateData any
+
+	// InjectMetadata is as in Migrator.
+	InjectMetadata bool
+
+	// MigrationFormat is as in Migrator.
+	MigrationFormat MigrationFormat
+
+	// Driver, if non-empty, makes Validate check only the migrations that
+	// would be run against that driver. Otherwise, the migrations specific to
+	// every driver are checked together.
+	Driver string
+
+	// Strict, if true, makes Validate return an error if there are any
+	// warnings.
+	Strict bool
+}
+
+// Validate checks that the migrations in fsys are well-formed. It returns an
+// error if they are not, and a description of each questionable, but not
+// invalid, thing it finds:
+//
+// Migrations that contain no statements, because they are empty or contain
+// only comments. Such migrations do nothing when run.
+//
+// Down migrations that contain no statements.
+//
+// Migrations with a "-- sqlcc:skip" directive, which are meant to be disabled
+// only temporarily.
+//
+// Migrations whose versions are zero-padded differently than the others, or
+// that have the same name as another migration apart from their version.
+func Validate(fsys fs.FS, opts ValidateOptions) ([]string, error) {
+	parseOpts := parseOptions{
+		namePattern:    opts.NamePattern,
+		extensions:     opts.Extensions,
+		expandEnv:      opts.ExpandEnv,
+		templateData:   opts.TemplateData,
+		injectMetadata: opts.InjectMetadata,
+		driver:         opts.Driver,
+		format:         opts.MigrationFormat,
+	}
+
+	migrations, err := parseMigrations(fsys, parseOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Contiguous {
+		if gaps := versionGaps(migrations); len(gaps) > 0 {
+			return nil, fmt.Errorf("migration versions are not contiguous, missing: %s", strings.Join(gaps, ", "))
+		}
+	}
+
+	warnings := nameWarnings(migrations, parseOpts)
+	for _, mig := range migrations {
+		// the driver is not known, so split the way standard SQL would
+		if len(splitStatements("", mig.upQuery)) == 0 {
+			warnings = append(warnings, fmt.Sprintf("empty migration: %q contains no statements", mig.name))
+		}
+
+		if mig.hasDown && len(splitStatements("", mig.downQuery)) == 0 {
+			name := mig.name
+			if mig.downName != "" {
+				name = mig.downName
+			}
+
+			warnings = append(warnings, fmt.Sprintf("empty down migration: %q contains no statements", name))
+		}
+
+		if mig.skip {
+			warnings = append(warnings, fmt.Sprintf("skipped migration: %q has a \"-- sqlcc:skip\" directive", mig.name))
+		}
+	}
+
+	if opts.Strict && len(warnings) > 0 {
+		return warnings, fmt.Errorf("found %d warning(s), and strict mode is enabled", len(warnings))
+	}
+
+	return warnings, nil
+}
+
+// nameWarnings returns warnings about the names of migrations, which must be
+// sorted by version:
+//
+// Migrations whose versions are zero-padded to a different width than the
+// others. This is usually a typo, and makes listing the migrations directory
+// show them out of version order.
+//
+// Migrations with the same name apart from their version, such as
+// "3_add_users.sql" and "5_add_users.sql". This usually means that a migration
+// was accidentally copied, or added on two branches that were then merged.
+func nameWarnings(migrations []migration, opts parseOptions) []string {
+	var warnings []string
+
+	// the width migrations are padded to is that of the first padded one
+	var padded string
+	namesByRest := map[string]string{}
+	for _, mig := range migrations {
+		version, rest := splitMigrationName(opts, mig.name)
+		if padded == "" && len(version) > 1 && version[0] == '0' {
+			padded = mig.name
+		}
+
+		// names like "3_.sql" have nothing to compare
+		if strings.Trim(rest, "_-. ") == "" {
+			continue
+		}
+
+		if other, ok := namesByRest[rest]; ok {
+			warnings = append(warnings, fmt.Sprintf("possible duplicate migration: %q and %q have the same name apart from their versions", other, mig.name))
+		} else {
+			namesByRest[rest] = mig.name
+		}
+	}
+
+	if padded != "" {
+		width, _ := splitMigrationName(opts, padded)
+		for _, mig := range migrations {
+			// versions can outgrow the padding, but not fall short of it
+			version, _ := splitMigrationName(opts, mig.name)
+			if len(version) < len(width) || len(version) > len(width) && version[0] == '0' {
+				warnings = append(warnings, fmt.Sprintf("inconsistent zero-padding: %q has a %d-digit version, but %q has a %d-digit one; rename it to match", mig.name, len(version), padded, len(width)))
+			}
+		}
+	}
+
+	return warnings
+}
+
+// versionGaps returns the ranges of versions missing between migrations, which
+// must be sorted by version. Each range is formatted as "n" or "n-m".
+func versionGaps(migrations []migration) []string {
+	var gaps []string
+	for i := 1; i < len(migrations); i++ {
+		from, to := migrations[i-1].version+1, migrations[i].version-1
+		switch {
+		case from == to:
+			gaps = append(gaps, strconv.FormatInt(from, 10))
+		case from < to:
+			gaps = append(gaps, fmt.Sprintf("%d-%d", from, to))
+		}
+	}
+
+	return gaps
+}
+
+// parseOptions control how migrations are listed and loaded.
+type parseOptions struct {
+	// namePattern is the pattern to parse versions from names with, or nil
+	// for the default pattern.
+	namePattern *regexp.Regexp
+
+	// extensions are the extensions of migration files, or nil for the
+	// default of ".sql".
+	extensions []string
+
+	// expandEnv is whether to substitute environment variables into queries.
+	expandEnv bool
+
+	// templateData, if non-nil, is the data to render queries as templates
+	// with.
+	templateData any
+
+	// injectMetadata is whether to substitute each migration's version and
+	// name into its queries.
+	injectMetadata bool
+
+	// driver, if non-empty, is the driver migrations are being listed for.
+	// Migrations specific to other drivers are ignored.
+	driver string
+
+	// format is the format migration files are written in.
+	format MigrationFormat
+}
+
+// defaultExtensions are the extensions of migration files, unless
+// Migrator.Extensions is set.
+var defaultExtensions = []string{".sql"}
+
+// extension returns which of opts.extensions name ends with, or the empty
+// string if none. If more than one matches, the longest does, so that
+// extensions like ".sql" and ".pg.sql" can be used together.
+func (opts parseOptions) extension(name string) string {
+	extensions := opts.extensions
+	if extensions == nil {
+		extensions = defaultExtensions
+	}
+
+	var ext string
+	for _, e := range extensions {
+		if strings.HasSuffix(name, e) && len(e) > len(ext) {
+			ext = e
+		}
+	}
+
+	return ext
+}
+
+// fileDriver returns the driver the migration file named name is specific to,
+// written as a suffix just before its extension and any ".up" or ".down", like
+// "5_foo.postgres.sql" or "5_foo.postgres.down.sql". It returns the empty
+// string if name is for every driver.
+func (opts parseOptions) fileDriver(name string) string {
+	base := strings.TrimSuffix(name, opts.extension(name))
+	if b := strings.TrimSuffix(base, ".up"); b != base {
+		base = b
+	} else {
+		base = strings.TrimSuffix(base, ".down")
+	}
+
+	for _, driver := range drivers {
+		if strings.HasSuffix(base, "."+driver) {
+			return driver
+		}
+	}
+
+	return ""
+}
+
+// conflicts returns whether migration files specific to drivers a and b, either
+// of which may be the empty string for every driver, could both be selected for
+// the same driver.
+func conflicts(a, b string) bool {
+	return a == "" || b == "" || sameDatabase(a, b)
+}
+
+// ValidateExtensions checks that each of extensions begins with a "." and
+// contains no path separators.
+func ValidateExtensions(extensions []string) error {
+	if len(extensions) == 0 {
+		return fmt.Errorf("must contain at least one extension")
+	}
+
+	for _, ext := range extensions {
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext, `/\`) {
+			return fmt.Errorf("extension must begin with a \".\" and contain no slashes: %q", ext)
+		}
+	}
+
+	return nil
+}
+
+// parseMigrations reads the migrations at the root of fsys, sorted by version,
+// and loads all of them.
+func parseMigrations(fsys fs.FS, opts parseOptions) ([]migration, error) {
+	migrations, err := listMigrations(fsys, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadMigrations(migrations, loadConcurrency, func(mig *migration) error {
+		return mig.loadQuery(fsys, opts)
+	}); err != nil {
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+// listMigrations returns the migrations at the root of fsys, sorted by
+// version. Only the names of the migration files are used; the returned
+// migrations are not loaded.
+//
+// Migrations specific to a driver other than opts.driver are ignored. If
+// opts.driver is empty, the migrations specific to every driver are returned,
+// so more than one migration may have the same version, but no two that could
+// be run against the same driver.
+func listMigrations(fsys fs.FS, opts parseOptions) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations dir: %w", err)
+	}
+
+	migrationsByVersion := map[int64][]migration{}
+	downNamesByVersion := map[int64][]string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		ext := opts.extension(name)
+		if ext == "" {
+			continue
+		}
+
+		driver := opts.fileDriver(name)
+		if driver != "" && opts.driver != "" && !sameDatabase(driver, opts.driver) {
+			continue
+		}
+
+		version, err := parseMigrationName(opts.namePattern, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasSuffix(name, ".down"+ext) {
+			for _, other := range downNamesByVersion[version] {
+				if conflicts(opts.fileDriver(other), driver) {
+					return nil, duplicateVersionError("two down migrations", version, opts, other, name)
+				}
+			}
+
+			downNamesByVersion[version] = append(downNamesByVersion[version], name)
+			continue
+		}
+
+		for _, other := range migrationsByVersion[version] {
+			if conflicts(opts.fileDriver(other.name), driver) {
+				return nil, duplicateVersionError("two migrations", version, opts, other.name, name)
+			}
+		}
+
+		migrationsByVersion[version] = append(migrationsByVersion[version], migration{version: version, name: name})
+	}
+
+	for version, downNames := range downNamesByVersion {
+		for _, downName := range downNames {
+			var matched bool
+			for i, m := range migrationsByVersion[version] {
+				if opts.fileDriver(m.name) == opts.fileDriver(downName) {
+					migrationsByVersion[version][i].downName = downName
+					matched = true
+				}
+			}
+
+			if !matched {
+				return nil, fmt.Errorf("down migration has no matching up migration: %q", downName)
+			}
+		}
+	}
+
+	var migrations []migration
+	for _, m := range migrationsByVersion {
+		migrations = append(migrations, m...)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		if migrations[i].version != migrations[j].version {
+			return migrations[i].version < migrations[j].version
+		}
+
+		return migrations[i].name < migrations[j].name
+	})
+
+	return migrations, nil
+}
+
+// duplicateVersionError returns an error describing that the migrations named a
+// and b, which are of the given kind, both have the given version. Versions are
+// compared as numbers, so "001_foo.sql" and "1_bar.sql" have the same version;
+// when that is why a and b collide, the error says so.
+func duplicateVersionError(kind string, version int64, opts parseOptions, a, b string) error {
+	hint := "give one of them a different version"
+	if versionA, _ := splitMigrationName(opts, a); versionA != "" {
+		if versionB, _ := splitMigrationName(opts, b); versionB != versionA {
+			hint = fmt.Sprintf("versions are compared as numbers, so %q and %q are both version %d; %s", versionA, versionB, version, hint)
+		}
+	}
+
+	return fmt.Errorf("%s for same version %d: %q, %q (%s)", kind, version, a, b, hint)
+}
+
+// loadConcurrency is the most migrations sqlcc loads at once.
+const loadConcurrency = 16
+
+// loadMigrations calls load on each of migrations, loading up to concurrency of
+// them at once. Migrations are loaded concurrently, because directories with
+// many migrations are otherwise slow to read on a cold cache.
+//
+// If more than one migration fails to load, the error for the first of them in
+// migrations is returned, so that errors do not depend on the order loads
+// finish.
+func loadMigrations(migrations []migration, concurrency int, load func(*migration) error) error {
+	errs := make([]error, len(migrations))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(migrations); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = load(&migrations[i])
+			}
+		}()
+	}
+
+	for i := range migrations {
+		indexes <- i
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadQuery reads mig's files from fsys, and populates its queries, noTx, skip,
+// and checksum. It does nothing if mig is already loaded.
+func (mig *migration) loadQuery(fsys fs.FS, opts parseOptions) error {
+	if mig.loaded {
+		return nil
+	}
+
+	contents, query, err := readMigrationFile(fsys, mig.name, *mig, opts)
+	if err != nil {
+		return err
+	}
+
+	// checksums and directives are computed from the file as written, not
+	// from the query that is ultimately run
+	directives := strings.TrimPrefix(string(contents), utf8BOM)
+	mig.noTx = hasDirective(directives, "no-transaction")
+	mig.skip = hasDirective(directives, "skip")
+	mig.checksum = checksum(contents)
+	if opts.format == FormatGoose {
+		if err := mig.loadGooseQuery(query); err != nil {
+			return err
+		}
+
+		if mig.hasDown && mig.downName != "" {
+			return fmt.Errorf("down migration defined both in %q and with %q in %q", mig.downName, "-- +goose Down", mig.name)
+		}
+
+		mig.hasDown = mig.hasDown || mig.downName != ""
+	} else {
+		// a "-- +down" line conflicts with a down migration file even if
+		// nothing follows it
+		inlineDown := downDelimiterPattern.MatchString(query)
+		if inlineDown && mig.downName != "" {
+			return fmt.Errorf("down migration defined both in %q and with %q in %q", mig.downName, downDelimiter, mig.name)
+		}
+
+		mig.upQuery, mig.downQuery = splitMigrationQuery(query)
+		mig.hasDown = inlineDown || mig.downName != ""
+	}
+
+	if mig.downName != "" {
+		if _, mig.downQuery, err = readMigrationFile(fsys, mig.downName, *mig, opts); err != nil {
+			return err
+		}
+	}
+
+	mig.loaded = true
+	return nil
+}
+
+// loadChecksum reads mig's up migration file from fsys, and populates only its
+// checksum. Unlike loadQuery, it does not keep the file's contents in memory.
+func (mig *migration) loadChecksum(fsys fs.FS) error {
+	if mig.loaded || mig.checksum != "" {
+		return nil
+	}
+
+	contents, err := fs.ReadFile(fsys, mig.name)
+	if err != nil {
+		return fmt.Errorf("read migration file: %w", err)
+	}
+
+	mig.checksum = checksum(contents)
+	return nil
+}
+
+// readMigrationFile reads the file named name, which is one of mig's files,
+// from fsys, and returns both its contents and its query, which is its contents
+// rendered according to opts.
+func readMigrationFile(fsys fs.FS, name string, mig migration, opts parseOptions) ([]byte, string, error) {
+	contents, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("read migration file: %w", err)
+	}
+
+	query, err := decodeMigration(name, contents)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if opts.injectMetadata {
+		query = injectMetadata(query, mig)
+	}
+
+	if opts.templateData != nil {
+		if query, err = renderTemplate(name, query, opts.templateData); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if opts.expandEnv {
+		if query, err = expandEnv(name, query); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return contents, query, nil
+}
+
+// utf8BOM is the byte order mark some editors, mostly on Windows, put at the
+// start of UTF-8 files.
+const utf8BOM = "\uFEFF"
+
+// decodeMigration returns contents, the contents of the migration file named
+// name, as a string. A leading UTF-8 byte order mark is removed. It is an error
+// for contents to be UTF-16, or otherwise not valid UTF-8. Databases would
+// reject either with a confusing syntax error.
+func decodeMigration(name string, contents []byte) (string, error) {
+	if bytes.HasPrefix(contents, []byte{0xFE, 0xFF}) || bytes.HasPrefix(contents, []byte{0xFF, 0xFE}) {
+		return "", fmt.Errorf("migration %q is encoded as UTF-16, but must be UTF-8", name)
+	}
+
+	// UTF-16 files without a byte order mark are valid UTF-8, but plain text,
+	// let alone SQL, never contains NUL
+	if bytes.IndexByte(contents, 0) != -1 {
+		return "", fmt.Errorf("migration %q contains NUL bytes, and may be encoded as UTF-16, but must be UTF-8", name)
+	}
+
+	query := strings.TrimPrefix(string(contents), utf8BOM)
+	if !utf8.ValidString(query) {
+		line := 1
+		for i, r := range query {
+			if r == utf8.RuneError {
+				if _, size := utf8.DecodeRuneInString(query[i:]); size == 1 {
+					break
+				}
+			}
+
+			if r == '\n' {
+				line++
+			}
+		}
+
+		return "", fmt.Errorf("migration %q is not valid UTF-8, starting on line %d", name, line)
+	}
+
+	return query, nil
+}
+
+func hasMigration(migrations []migration, version int64) bool {
+	for _, m := range migrations {
+		if m.version == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DefaultNamePattern is the pattern migration names must match, unless
+// Migrator.NamePattern is set. Which files are migrations is decided by their
+// extension, not by the pattern, so it matches any extension.
+const DefaultNamePattern = `(?P<version>\d+)_.*`
+
+var defaultNamePattern = regexp.MustCompile(DefaultNamePattern)
+
+// CompileNamePattern compiles a pattern for migration names. The pattern must
+// have a named capture group "version", which must match the migration's
+// version in decimal digits.
+func CompileNamePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if re.SubexpIndex("version") == -1 {
+		return nil, fmt.Errorf("pattern must have a capture group named version, like (?P<version>\\d+): %q", pattern)
+	}
+
+	return re, nil
+}
+
+func parseMigrationName(namePattern *regexp.Regexp, name string) (int64, error) {
+	if namePattern == nil {
+		namePattern = defaultNamePattern
+	}
+
+	match := namePattern.FindStringSubmatch(name)
+	if match == nil {
+		if namePattern == defaultNamePattern {
+			return 0, fmt.Errorf("migration name must begin with digits followed by underscore (`_`): %q", name)
+		}
+
+		return 0, fmt.Errorf("migration name does not match name pattern %q: %q", namePattern, name)
+	}
+
+	n, err := strconv.ParseInt(match[namePattern.SubexpIndex("version")], 10, 64)
+	if errors.Is(err, strconv.ErrSyntax) {
+		return 0, fmt.Errorf("migration version must be decimal digits: %q", name)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("migration version is too large: %q", name)
+	}
+
+	if n == 0 {
+		return 0, fmt.Errorf("migration version must be nonzero: %q", name)
+	}
+
+	return n, nil
+}
+
+// splitMigrationName returns the digits of the version in name, as written,
+// and the rest of name without them or its extension. It returns empty strings
+// if name does not match opts.namePattern.
+func splitMigrationName(opts parseOptions, name string) (version, rest string) {
+	namePattern := opts.namePattern
+	if namePattern == nil {
+		namePattern = defaultNamePattern
+	}
+
+	match := namePattern.FindStringSubmatchIndex(name)
+	if match == nil {
+		return "", ""
+	}
+
+	i := 2 * namePattern.SubexpIndex("version")
+	start, end := match[i], match[i+1]
+
+	rest = strings.TrimSuffix(name[:start]+name[end:], opts.extension(name))
+	if r := strings.TrimSuffix(rest, ".up"); r != rest {
+		rest = r
+	} else {
+		rest = strings.TrimSuffix(rest, ".down")
+	}
+
+	return name[start:end], rest
+}
+
+// expandEnv replaces ${var} or $var in the query of the migration named name
+// with the value of the environment variable var. It is an error for query to
+// reference an unset variable.
+func expandEnv(name, query string) (string, error) {
+	var unset []string
+	expanded := os.Expand(query, func(key string) string {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			unset = append(unset, key)
+		}
+
+		return value
+	})
+
+	if len(unset) > 0 {
+		return "", fmt.Errorf("migration %q references unset environment variables: %s", name, strings.Join(unset, ", "))
+	}
+
+	return expanded, nil
+}
+
+// metadataPattern matches the placeholders injectMetadata replaces.
+var metadataPattern = regexp.MustCompile(`\{\{\s*\.(Version|Name)\s*\}\}`)
+
+// injectMetadata replaces "{{ .Version }}" and "{{ .Name }}" in query with the
+// version and name of mig. The version is written without any zero-padding,
+// and the name is that of mig's up migration file. Nothing else in query is
+// treated specially.
+func injectMetadata(query string, mig migration) string {
+	return metadataPattern.ReplaceAllStringFunc(query, func(placeholder string) string {
+		if metadataPattern.FindStringSubmatch(placeholder)[1] == "Version" {
+			return strconv.FormatInt(mig.version, 10)
+		}
+
+		return mig.name
+	})
+}
+
+// renderTemplate renders the query of the migration named name as a
+// text/template, with data as dot. Errors from the template package include
+// name and the line the error occurred on. It is an error for the template to
+// use a key that data does not have.
+func renderTemplate(name, query string, data any) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("parse migration template: %w", err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render migration template: %w", err)
+	}
+
+	return b.String(), nil
+}
+
+const downDelimiter = "-- +down"
+
+var downDelimiterPattern = regexp.MustCompile(`(?m)^--\s*\+down\s*$`)
+
+// splitMigrationQuery splits the contents of a migration file into its up and
+// down halves. Everything after a line consisting of "-- +down" is the down
+// half. Files without such a line are entirely up.
+func splitMigrationQuery(query string) (string, string) {
+	loc := downDelimiterPattern.FindStringIndex(query)
+	if loc == nil {
+		return query, ""
+	}
+
+	return query[:loc[0]], query[loc[1]:]
+}
+
+// hasDirective reports whether query has a "-- sqlcc:<name>" comment in the
+// lines at the top of the file. Directives may only appear in the leading
+// "-- sqlcc:" lines of a file.
+func hasDirective(query, name string) bool {
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "-- sqlcc:") {
+			return false
+		}
+
+		if strings.TrimPrefix(line, "-- sqlcc:") == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,168 @@
+package migrator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestListMigrationsTimestamps(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20220315093000_add_index.sql":         {},
+		"20211231235959_create_users.sql":      {},
+		"20220315092959_add_column.sql":        {},
+		"20220101000000_create_posts.sql":      {},
+		"20220101000000_create_posts.down.sql": {},
+		"README.md":                            {},
+	}
+
+	migrations, err := listMigrations(fsys, parseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		version int64
+		name    string
+	}{
+		{20211231235959, "20211231235959_create_users.sql"},
+		{20220101000000, "20220101000000_create_posts.sql"},
+		{20220315092959, "20220315092959_add_column.sql"},
+		{20220315093000, "20220315093000_add_index.sql"},
+	}
+
+	if len(migrations) != len(want) {
+		t.Fatalf("got %d migrations, want %d: %+v", len(migrations), len(want), migrations)
+	}
+
+	for i, w := range want {
+		if migrations[i].version != w.version || migrations[i].name != w.name {
+			t.Errorf("migration %d: got %d %q, want %d %q", i, migrations[i].version, migrations[i].name, w.version, w.name)
+		}
+	}
+
+	if got := migrations[1].downName; got != "20220101000000_create_posts.down.sql" {
+		t.Errorf("got down migration %q for 20220101000000", got)
+	}
+}
+
+func TestListMigrationsTimestampErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{
+			"same timestamp",
+			[]string{"20220101000000_a.sql", "20220101000000_b.sql"},
+			`two migrations for same version 20220101000000: "20220101000000_a.sql", "20220101000000_b.sql" (give one of them a different version)`,
+		},
+		{
+			"same timestamp with leading zero",
+			[]string{"020220101000000_a.sql", "20220101000000_b.sql"},
+			`versions are compared as numbers, so "020220101000000" and "20220101000000" are both version 20220101000000`,
+		},
+		{
+			"same timestamp down migrations",
+			[]string{"20220101000000_a.sql", "20220101000000_a.down.sql", "20220101000000_b.down.sql"},
+			`two down migrations for same version 20220101000000`,
+		},
+		{
+			"no digits",
+			[]string{"20220101000000_a.sql", "create_b.sql"},
+			`migration name must begin with digits followed by underscore (` + "`_`" + `): "create_b.sql"`,
+		},
+		{
+			"no underscore",
+			[]string{"20220101000000-a.sql"},
+			`migration name must begin with digits followed by underscore`,
+		},
+		{
+			"too large",
+			[]string{"20220101000000_a.sql", "20220101000000000000_b.sql"},
+			`migration version is too large: "20220101000000000000_b.sql"`,
+		},
+		{
+			"zero",
+			[]string{"00000000000000_a.sql"},
+			`migration version must be nonzero: "00000000000000_a.sql"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tt.files {
+				fsys[name] = &fstest.MapFile{}
+			}
+
+			_, err := listMigrations(fsys, parseOptions{})
+			if err == nil {
+				t.Fatal("got no error")
+			}
+
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMigrationsDownConflict(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		up   string
+	}{
+		{"down half", "create table a (x int);\n-- +down\ndrop table a;\n"},
+		{"empty down half", "create table a (x int);\n-- +down\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{
+				"1_a.sql":      {Data: []byte(tt.up)},
+				"1_a.down.sql": {Data: []byte("drop table a;\n")},
+			}
+
+			_, err := parseMigrations(fsys, parseOptions{})
+			want := `down migration defined both in "1_a.down.sql" and with "-- +down" in "1_a.sql"`
+			if err == nil || err.Error() != want {
+				t.Errorf("got error %v, want %q", err, want)
+			}
+		})
+	}
+}
+
+func BenchmarkLoadMigrations(b *testing.B) {
+	const n = 5000
+
+	dir := b.TempDir()
+	for i := 1; i <= n; i++ {
+		name := fmt.Sprintf("%d_migration_%d.sql", i, i)
+		query := fmt.Sprintf("create table t%d (id bigint primary key);\n", i)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(query), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	fsys := os.DirFS(dir)
+	for _, concurrency := range []int{1, loadConcurrency} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				migrations, err := listMigrations(fsys, parseOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if len(migrations) != n {
+					b.Fatalf("got %d migrations, want %d", len(migrations), n)
+				}
+
+				if err := loadMigrations(migrations, concurrency, func(mig *migration) error {
+					return mig.loadQuery(fsys, parseOptions{})
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
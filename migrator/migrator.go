@@ -0,0 +1,1843 @@
+// Package migrator implements sqlcc's migration engine, so that it can be used
+// from within other Go programs.
+//
+// A Migrator runs the migrations in an fs.FS against a *sql.DB, and keeps track
+// of which migrations have been run in a state table in that same database.
+// The sqlcc command-line tool is a thin wrapper around this package.
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migrator runs migrations against a database.
+type Migrator struct {
+	// DB is the database to run migrations against. The caller owns DB, and so
+	// controls its connection pool; Migrator never closes it.
+	//
+	// On MySQL and Postgres, Migrator uses two connections at once: one to
+	// hold a lock on the state table, and one to run migrations. If DB is
+	// limited to a single open connection, Migrator will wait forever.
+	DB *sql.DB
+
+	// Driver is the kind of database DB is connected to. It must be one of
+	// "mysql", "postgres", "sqlite3", "sqlite", "sqlserver", "cockroachdb", or
+	// "clickhouse". "sqlite3" and "sqlite" are both SQLite, and differ only in
+	// which database/sql driver DB is expected to use.
+	Driver string
+
+	// StateTable is the name of the table where the Migrator keeps state. It
+	// must satisfy ValidateStateTable.
+	StateTable string
+
+	// StateSchema, if set, is the schema StateTable is in, in which case
+	// StateTable must not include a schema itself. It must satisfy
+	// ValidateStateSchema.
+	//
+	// Unlike a schema given as part of StateTable, StateSchema and StateTable
+	// are each quoted when they are interpolated into SQL. On Postgres, this
+	// makes them case-sensitive.
+	StateSchema string
+
+	// QuoteStateTable, if true, quotes StateTable when it is interpolated into
+	// SQL, in the syntax of Driver, so that it may be a reserved word like
+	// "order". If StateTable includes a schema, the schema and table are quoted
+	// independently. On Postgres, this makes StateTable case-sensitive. When
+	// StateSchema is set, StateTable is always quoted.
+	QuoteStateTable bool
+
+	// StateVersionColumn and StateDirtyColumn, if set, are the names of the
+	// state table's version and dirty columns, instead of "version" and
+	// "dirty". This lets a Migrator adopt an existing table, such as one
+	// created by another tool, as its state table. They must satisfy
+	// ValidateStateColumn.
+	StateVersionColumn string
+	StateDirtyColumn   string
+
+	// Compat is the layout and meaning of the state table. The zero value is
+	// sqlcc's own; see Compat for the others. It must satisfy ValidateCompat.
+	Compat Compat
+
+	// Migrations contains the migration files, at its root. To use migrations
+	// in a subdirectory of an embed.FS, use fs.Sub. Files are read from
+	// Migrations concurrently, so it must be safe for concurrent use, as
+	// os.DirFS and embed.FS are.
+	//
+	// A migration file may be specific to a driver, by naming the driver just
+	// before its extension, like "5_foo.postgres.sql". Such files are ignored
+	// unless Driver is that driver; "sqlite3" and "sqlite" are interchangeable.
+	// It is an error for two files that could both be run against Driver to
+	// have the same version.
+	Migrations fs.FS
+
+	// NamePattern is the pattern that the names of migration files must
+	// match. Its capture group named "version" is the migration's version. If
+	// nil, DefaultNamePattern is used. Use CompileNamePattern to construct
+	// it.
+	NamePattern *regexp.Regexp
+
+	// SearchPath, if set, is the schemas that migrations are run with in
+	// effect, so that the objects they refer to need not be qualified. On
+	// Postgres and CockroachDB, it is the search path; on MySQL, it must be a
+	// single database, which is made the default database. It is ignored on
+	// SQLite, and not supported on SQL Server or ClickHouse. Use
+	// ValidateSearchPath to check it.
+	//
+	// SearchPath only applies to the contents of migrations; it is reset
+	// after each migration, so the state table is unaffected by it.
+	SearchPath []string
+
+	// Extensions are the file extensions, such as ".sql" or ".pgsql", of the
+	// files in Migrations that are migrations. Other files are ignored. If
+	// nil, only ".sql" files are migrations. Use ValidateExtensions to check
+	// them.
+	Extensions []string
+
+	// ExpandEnv, if true, replaces references to environment variables in
+	// migrations, written as ${var} or $var, with their values. It is an error
+	// for a migration to reference an unset variable. Because every "$"
+	// begins a reference, migrations cannot contain a literal "$" when
+	// ExpandEnv is set.
+	ExpandEnv bool
+
+	// TemplateData, if non-nil, causes every migration to be rendered as a
+	// text/template, with TemplateData as dot, before it is run. Templates are
+	// rendered before environment variables are expanded. It is an error for
+	// a template to use a map key that TemplateData does not have.
+	TemplateData any
+
+	// InjectMetadata, if true, replaces "{{ .Version }}" and "{{ .Name }}" in
+	// every migration with the migration's own version and file name, before
+	// it is run. Unlike TemplateData, nothing else in a migration is treated
+	// specially. Metadata is injected before templates are rendered.
+	InjectMetadata bool
+
+	// MigrationFormat is the format migration files are written in. The zero
+	// value is sqlcc's own; see MigrationFormat for the others. With
+	// FormatGoose, the statements of each migration are always run
+	// separately, as Goose runs them, whether or not SplitStatements is set.
+	MigrationFormat MigrationFormat
+
+	// TxMode controls whether operations are run in a transaction.
+	TxMode TxMode
+
+	// TxAttempts is, for CockroachDB in transactional mode, the maximum number
+	// of times to attempt a transaction. Zero means 3.
+	TxAttempts int
+
+	// SplitStatements, if true, splits each migration into its individual
+	// statements, and runs them one at a time. This is useful for drivers that
+	// cannot run multiple statements at once, such as MySQL without
+	// multiStatements=true in its DSN.
+	SplitStatements bool
+
+	// LockTimeout is, for Postgres and MySQL, how long to wait to acquire the
+	// lock that prevents concurrent sqlcc processes from modifying the same
+	// state table. Zero means one minute.
+	LockTimeout time.Duration
+
+	// BeforeEach, if non-nil, is called before each migration is applied, by
+	// Migrate or Redo. If it returns an error, the migration is not run, and
+	// the state is left as it was.
+	//
+	// If a transaction is retried, BeforeEach and AfterEach are called again
+	// for the migrations in it.
+	BeforeEach func(ctx context.Context, mig Migration) error
+
+	// AfterEach, if non-nil, is called after each migration is applied, but
+	// before it is recorded as applied. If it returns an error, the migration
+	// is treated as having failed: if it was run in a transaction, the
+	// transaction is rolled back, and otherwise the state is left dirty.
+	AfterEach func(ctx context.Context, mig Migration) error
+
+	// OnMigrationStart and OnMigrationEnd, if non-nil, are called immediately
+	// before and after each migration's up SQL is run, including by Apply and
+	// Redo, with the error the SQL failed with, if any. Unlike BeforeEach and
+	// AfterEach, they cannot fail a migration, so they are meant for
+	// observability, such as emitting metrics. OnMigrationStart is called after
+	// the state is marked dirty. Neither is called for skipped migrations, or
+	// in dry-run mode.
+	OnMigrationStart func(mig Migration)
+	OnMigrationEnd   func(mig Migration, err error)
+
+	// Logger receives an Event for each migration as it is run. If nil, a
+	// TextLogger writing to Output is used.
+	Logger Logger
+
+	// Output is where the names of migrations are written as they are run, if
+	// Logger is nil. If nil, os.Stdout is used. To write nothing, use
+	// io.Discard.
+	Output io.Writer
+
+	// DebugOutput, if non-nil, is where every statement run against the
+	// database is written, along with how long it took, and when transactions
+	// begin, commit, and roll back.
+	DebugOutput io.Writer
+
+	// Now, if non-nil, is used in place of time.Now to get the times written
+	// to the database, such as when each migration was applied. This is
+	// useful for making those times deterministic in tests. How long
+	// migrations take is always measured with the real clock.
+	Now func() time.Time
+}
+
+// TxMode controls whether a Migrator runs operations in a transaction.
+type TxMode int
+
+const (
+	// TxAuto is like TxAlways, except for MySQL and ClickHouse, where it is
+	// like TxNever. MySQL does not have transactional DDL, and ClickHouse does
+	// not have transactions.
+	//
+	// Unlike TxAlways, TxAuto will split its transaction in order to run
+	// migrations that are marked as not to be run in a transaction.
+	TxAuto TxMode = iota
+
+	// TxAlways runs each operation in a single transaction.
+	TxAlways
+
+	// TxNever runs operations without transactions.
+	TxNever
+
+	// TxPerMigration is like TxAlways, except that Migrate runs each migration
+	// in a transaction of its own. If a migration fails, only it is rolled
+	// back, and the migrations before it stay committed. Like TxAuto, it runs
+	// migrations that are marked as not to be run in a transaction without
+	// one.
+	TxPerMigration
+)
+
+// InitOptions are options for Init.
+type InitOptions struct {
+	// Baseline is the version to initialize the state table at. Zero means no
+	// migrations have been run. If nonzero, there must be a migration with
+	// that version.
+	Baseline int64
+
+	// Recreate, if true, makes Init drop and create anew a state table that
+	// exists, but does not have the columns sqlcc expects, instead of
+	// returning an error. Whatever state the old table held is lost.
+	Recreate bool
+}
+
+// Init creates the state table, at the version opts.Baseline.
+//
+// Init is idempotent. If the state table already exists, Init leaves it as-is,
+// except that it returns an error if opts.Baseline is nonzero and differs from
+// the existing version. If the state table exists but has no row, such as
+// because a previous Init was interrupted, Init inserts one. If a table with
+// the state table's name exists, but does not have the columns of a state
+// table, Init returns an error, unless opts.Recreate is set.
+func (m *Migrator) Init(ctx context.Context, opts InitOptions) error {
+	var migrations []migration
+	if opts.Baseline != 0 {
+		var err error
+		migrations, err = parseMigrations(m.Migrations, m.parseOptions())
+		if err != nil {
+			return err
+		}
+
+		if !hasMigration(migrations, opts.Baseline) {
+			return fmt.Errorf("no migration with baseline version: %d", opts.Baseline)
+		}
+	}
+
+	return m.withLock(ctx, func() error {
+		exists := m.stateTableExists(ctx)
+
+		return m.withTx(ctx, func(q queryer) error {
+			// the transaction may be retried, so start afresh each attempt
+			exists := exists
+			if exists {
+				if err := m.verifyStateTable(ctx, q); err != nil {
+					if !opts.Recreate {
+						return err
+					}
+
+					if _, err := q.ExecContext(ctx, fmt.Sprintf(dropStateTableSQL, m.stateTable())); err != nil {
+						return fmt.Errorf("drop state table: %w", err)
+					}
+
+					exists = false
+				}
+			}
+
+			// whether the state's row is created, rather than already
+			// existing
+			seeded := !exists
+
+			if exists {
+				state, err := m.getState(ctx, q)
+				switch {
+				case errors.Is(err, ErrNoState):
+					if err := m.seedState(ctx, q, opts.Baseline); err != nil {
+						return err
+					}
+
+					seeded = true
+				case err != nil:
+					return err
+				case opts.Baseline != 0 && state.Version != opts.Baseline:
+					return fmt.Errorf("state table already exists at version %d, will not baseline at version %d", state.Version, opts.Baseline)
+				}
+			} else {
+				if err := m.initState(ctx, q, opts.Baseline); err != nil {
+					return err
+				}
+			}
+
+			if err := m.initChecksums(ctx, q); err != nil {
+				return err
+			}
+
+			if seeded {
+				if err := m.setBaselineChecksums(ctx, q, migrations, opts.Baseline); err != nil {
+					return err
+				}
+			}
+
+			return m.initHistory(ctx, q)
+		})
+	})
+}
+
+// Baseline marks the migrations up to and including version as already run,
+// without running them, creating the state table if it does not already
+// exist. There must be a migration with that version.
+//
+// Baseline is meant for adopting sqlcc on a database whose schema predates it.
+// Unlike Reset, Baseline returns an error if the state table already exists at
+// a nonzero version, or is dirty.
+func (m *Migrator) Baseline(ctx context.Context, version int64) error {
+	migrations, err := parseMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return err
+	}
+
+	if !hasMigration(migrations, version) {
+		return fmt.Errorf("no migration with baseline version: %d", version)
+	}
+
+	return m.withLock(ctx, func() error {
+		exists := m.stateTableExists(ctx)
+
+		return m.withTx(ctx, func(q queryer) error {
+			if exists {
+				state, err := m.getState(ctx, q)
+				if err != nil {
+					return err
+				}
+
+				if state.Dirty {
+					return fmt.Errorf("%w, will not baseline", ErrDirty)
+				}
+
+				if state.Version != 0 {
+					return fmt.Errorf("state table already exists at version %d, will not baseline", state.Version)
+				}
+
+				if err := m.setState(ctx, q, State{Version: version}); err != nil {
+					return err
+				}
+			} else {
+				if err := m.initState(ctx, q, version); err != nil {
+					return err
+				}
+			}
+
+			if err := m.initChecksums(ctx, q); err != nil {
+				return err
+			}
+
+			if err := m.setBaselineChecksums(ctx, q, migrations, version); err != nil {
+				return err
+			}
+
+			return m.initHistory(ctx, q)
+		})
+	})
+}
+
+// setBaselineChecksums records the checksums of the migrations up to and
+// including version, as though they had been run, so that later changes to
+// them are detected.
+func (m *Migrator) setBaselineChecksums(ctx context.Context, q queryer, migrations []migration, version int64) error {
+	for _, mig := range migrations {
+		if mig.version > version {
+			break
+		}
+
+		if err := m.setChecksum(ctx, q, mig); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Status returns the current state from the state table.
+func (m *Migrator) Status(ctx context.Context) (State, error) {
+	var s State
+	err := m.withTx(ctx, func(q queryer) error {
+		var err error
+		s, err = m.getState(ctx, q)
+		return err
+	})
+
+	return s, err
+}
+
+// CheckStateTable checks that the state table exists, returning
+// ErrNotInitialized if it does not, and that it has the columns sqlcc expects.
+// It does not read or change the state.
+func (m *Migrator) CheckStateTable(ctx context.Context) error {
+	return m.withTx(ctx, func(q queryer) error {
+		if _, err := m.getStateColumns(ctx, q); err != nil {
+			return err
+		}
+
+		return m.verifyStateTable(ctx, q)
+	})
+}
+
+// List returns all of the migrations, in version order. It does not use the
+// database, or read the contents of the migration files.
+func (m *Migrator) List() ([]Migration, error) {
+	migrations, err := listMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Migration
+	for _, mig := range migrations {
+		list = append(list, mig.public())
+	}
+
+	return list, nil
+}
+
+// MigrationInfo describes a migration and its files.
+type MigrationInfo struct {
+	Version int64
+	Name    string
+
+	// DownName is the name of the migration's down migration file, if it is
+	// in a separate file.
+	DownName string
+
+	// HasDown is whether the migration has a down migration, either in
+	// DownName or in its own file after a "-- +down" line.
+	HasDown bool
+
+	// Skipped is whether the migration has a "-- sqlcc:skip" directive.
+	Skipped bool
+}
+
+// Describe returns a description of each migration, in version order. Unlike
+// List, it reads and parses the migration files, so it fails if any of them
+// are invalid. It does not use the database.
+func (m *Migrator) Describe() ([]MigrationInfo, error) {
+	migrations, err := parseMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []MigrationInfo
+	for _, mig := range migrations {
+		infos = append(infos, MigrationInfo{
+			Version:  mig.version,
+			Name:     mig.name,
+			DownName: mig.downName,
+			HasDown:  mig.hasDown,
+			Skipped:  mig.skip,
+		})
+	}
+
+	return infos, nil
+}
+
+// MultiStatementMigrations returns the migrations whose up or down migrations
+// contain more than one statement, in version order. MySQL only runs such
+// migrations if multiStatements is enabled in the DSN, or if statements are run
+// separately, per SplitStatements and MigrationFormat. Skipped migrations are
+// never run, so they are not returned. It does not use the database.
+func (m *Migrator) MultiStatementMigrations() ([]Migration, error) {
+	migrations, err := parseMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	var multi []Migration
+	for _, mig := range migrations {
+		if mig.skip {
+			continue
+		}
+
+		if len(m.statements(mig.upQuery)) > 1 || len(m.statements(mig.downQuery)) > 1 {
+			multi = append(multi, mig.public())
+		}
+	}
+
+	return multi, nil
+}
+
+// UpQuery returns the SQL that applying mig would run: its file's contents, up
+// to its down migration if it has one, after it has been rendered as a template
+// and had environment variables expanded. It does not use the database.
+//
+// Applying a migration with a "-- sqlcc:skip" directive runs nothing, so
+// UpQuery returns the empty string for such migrations.
+func (m *Migrator) UpQuery(mig Migration) (string, error) {
+	// only the up migration's file is needed, so the other migrations do not
+	// need to be listed
+	full := migration{version: mig.Version, name: mig.Name}
+	if err := full.loadQuery(m.Migrations, m.parseOptions()); err != nil {
+		return "", err
+	}
+
+	if full.skip {
+		return "", nil
+	}
+
+	return full.upQuery, nil
+}
+
+// Pending returns the migrations newer than the current state, in version
+// order.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	migrations, err := listMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	err = m.withTx(ctx, func(q queryer) error {
+		state, err := m.getState(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		pending = nil
+		for _, mig := range migrations {
+			if mig.version > state.Version {
+				pending = append(pending, mig.public())
+			}
+		}
+
+		return nil
+	})
+
+	return pending, err
+}
+
+// ResetOptions are options for Reset.
+type ResetOptions struct {
+	// Force, if true, allows Reset to lower the version, or to make the state
+	// dirty. Both make sqlcc run migrations that have already been run, and so
+	// are refused by default.
+	Force bool
+}
+
+// ErrUnsafeReset is returned, wrapped, when Reset is refused because it would
+// lower the version or make the state dirty, and ResetOptions.Force is not set.
+var ErrUnsafeReset = errors.New("unsafe reset")
+
+// Reset overwrites the state in the state table with s, and returns the state
+// from before. If the state table has no row, Reset inserts one, and returns
+// the zero State as the state from before.
+func (m *Migrator) Reset(ctx context.Context, s State, opts ResetOptions) (State, error) {
+	return m.reset(ctx, opts, func(*State) (State, error) {
+		return s, nil
+	})
+}
+
+// ErrNotDirty is returned, wrapped, when ClearDirty is refused because the state
+// is not dirty, and ResetOptions.Force is not set.
+var ErrNotDirty = errors.New("state is not dirty")
+
+// ClearDirty clears the dirty flag of the state in the state table, keeping its
+// version, and returns the state from before. The version of a dirty state is
+// that of the migration before the one that failed, so this is for when the
+// database is as that version left it, and the failed migration can be retried.
+//
+// Unless opts.Force is set, ClearDirty returns an error if the state is not
+// dirty, in which case it would do nothing.
+func (m *Migrator) ClearDirty(ctx context.Context, opts ResetOptions) (State, error) {
+	return m.reset(ctx, opts, func(prev *State) (State, error) {
+		if prev == nil {
+			return State{}, ErrNoState
+		}
+
+		if !prev.Dirty && !opts.Force {
+			return State{}, fmt.Errorf("%w, so there is nothing to clear", ErrNotDirty)
+		}
+
+		return State{Version: prev.Version}, nil
+	})
+}
+
+// reset overwrites the state in the state table with the one next returns,
+// which is given the state from before, or nil if the state table has no row.
+// It returns the state from before, or the zero State if there was no row.
+func (m *Migrator) reset(ctx context.Context, opts ResetOptions, next func(prev *State) (State, error)) (State, error) {
+	var prev State
+	err := m.withLock(ctx, func() error {
+		return m.withTx(ctx, func(q queryer) error {
+			var err error
+			prev, err = m.getState(ctx, q)
+			noRow := errors.Is(err, ErrNoState)
+			if noRow {
+				prev = State{}
+			} else if err != nil {
+				return err
+			}
+
+			var s State
+			if noRow {
+				s, err = next(nil)
+			} else {
+				s, err = next(&prev)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if !opts.Force {
+				if s.Version < prev.Version {
+					return fmt.Errorf("%w: would lower version from %d to %d", ErrUnsafeReset, prev.Version, s.Version)
+				}
+
+				if s.Dirty && !prev.Dirty {
+					return fmt.Errorf("%w: would make state dirty", ErrUnsafeReset)
+				}
+			}
+
+			if noRow {
+				if err := m.seedState(ctx, q, s.Version); err != nil {
+					return err
+				}
+			}
+
+			return m.setState(ctx, q, s)
+		})
+	})
+
+	return prev, err
+}
+
+// MigrateOptions are options for Migrate.
+type MigrateOptions struct {
+	// DryRun, if true, prevents any migrations from being run.
+	DryRun bool
+
+	// To, if nonzero, is the version to migrate up to. There must be a
+	// migration with that version. If zero, Migrate runs all pending
+	// migrations.
+	To int64
+
+	// NoVerify, if true, skips checking that already-applied migrations have
+	// not been modified since they were applied.
+	NoVerify bool
+
+	// AllowDirty, if true, makes Migrate clear the dirty flag and continue
+	// from the current version, instead of returning an error, if the state
+	// is dirty. This runs the migration that made the state dirty again, so it
+	// is only safe if that migration is idempotent. AllowDirty is not supported
+	// with CompatGolangMigrate.
+	AllowDirty bool
+
+	// NoState, if true, makes Migrate run every migration, up to To if set,
+	// without using the state table at all: there is no current version, so
+	// every migration is run, in version order, each in a transaction of its
+	// own if TxMode calls for one. Nothing is recorded, so running Migrate
+	// again runs every migration again. This is meant for setting up
+	// throwaway databases, such as in tests. NoState may not be combined with
+	// AllowDirty or Savepoints, and implies NoVerify.
+	NoState bool
+
+	// Savepoints, if true, runs each migration within a savepoint of the
+	// enclosing transaction. If a migration fails, only that migration is
+	// rolled back, and the migrations before it in the same transaction are
+	// committed, before Migrate returns the error. Savepoints requires that
+	// migrations run in a transaction, and is not supported on ClickHouse.
+	Savepoints bool
+
+	// PretendVersion, if non-nil, makes Migrate use it as the current version,
+	// instead of the version in the state table, as if the state were clean
+	// and at that version. This is for previewing which migrations would run
+	// from an arbitrary starting point, so it requires DryRun, and may not be
+	// combined with NoState.
+	PretendVersion *int64
+
+	// ContinueOnError, if true, makes Migrate go on to the next migration when
+	// one fails, instead of stopping, and return a MigrationErrors describing
+	// every failure once all of the migrations have been tried. The state is
+	// left clean, at the version of the last migration that succeeded.
+	//
+	// Each migration is run in a transaction of its own, as with
+	// TxPerMigration, so that a failure only rolls back that migration.
+	// Without transactions, a failed migration may have been partly applied,
+	// and the state is not marked dirty. Either way, a failed migration older
+	// than the current version is not run again by Migrate; use Apply to run
+	// it once it is fixed. ContinueOnError may not be combined with TxAlways.
+	ContinueOnError bool
+
+	// OutOfOrder, if true, makes Migrate also run the migrations older than
+	// the current version that have not been applied, such as ones merged
+	// after a newer migration was already applied. Which migrations have been
+	// applied is known from the checksums table. Migrations older than the
+	// oldest recorded checksum may have been run before sqlcc recorded
+	// checksums, so they are assumed to have been applied.
+	//
+	// Migrations run out of order are run first, in version order, each in a
+	// transaction of its own if TxMode calls for one, and do not change the
+	// state's version. OutOfOrder may not be combined with TxAlways,
+	// AllowDirty, NoState, or PretendVersion.
+	OutOfOrder bool
+}
+
+// MigrationResult describes a migration that Migrate ran, or would have run.
+type MigrationResult struct {
+	Version int64
+	Name    string
+
+	// Applied is whether the migration was run. It is false in dry-run mode.
+	Applied bool
+
+	// Skipped is whether the migration has a "-- sqlcc:skip" directive. Its
+	// SQL is not run, and Applied is false, even though the version is
+	// advanced past it.
+	Skipped bool
+
+	// Duration is how long the migration took to run.
+	Duration time.Duration
+
+	// Err is the error the migration failed with, if MigrateOptions.
+	// ContinueOnError was set. Applied is false if Err is non-nil.
+	Err error
+
+	// OutOfOrder is whether the migration is older than the current version,
+	// and was run because MigrateOptions.OutOfOrder was set. Running it did
+	// not change the state's version.
+	OutOfOrder bool
+}
+
+// MigrationError is a migration that failed, and the error it failed with.
+type MigrationError struct {
+	Migration Migration
+	Err       error
+}
+
+func (e *MigrationError) Error() string {
+	// errors from running a migration already name the migration
+	return e.Err.Error()
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// MigrationErrors is the error Migrate returns when MigrateOptions.
+// ContinueOnError is set and any migrations failed. It has an element for each
+// migration that failed, in the order they were run.
+type MigrationErrors []*MigrationError
+
+func (e MigrationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d migration(s) failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Migrate runs all migrations newer than the current state, in version order,
+// after any older migrations that have not been applied if opts.OutOfOrder is
+// set. It prints the name of each migration it runs, with its position among the
+// pending migrations, or would run if opts.DryRun is set, and returns a result
+// for each of them.
+//
+// If Migrate returns an error, the returned results describe the migrations
+// that were committed before the error. If opts.ContinueOnError is set, they
+// also describe the migrations that failed.
+//
+// Unless opts.NoVerify is set, Migrate first checks that the checksums of the
+// already-applied migrations match the checksums recorded when they were run.
+// Otherwise, only the files of the migrations to be run are read.
+func (m *Migrator) Migrate(ctx context.Context, opts MigrateOptions) ([]MigrationResult, error) {
+	// without a state table, there is nothing to lock
+	if opts.NoState {
+		return m.migrateWithoutState(ctx, opts)
+	}
+
+	var results []MigrationResult
+	err := m.withLock(ctx, func() error {
+		var err error
+		results, err = m.migrate(ctx, opts)
+		return err
+	})
+
+	return results, err
+}
+
+func (m *Migrator) migrate(ctx context.Context, opts MigrateOptions) ([]MigrationResult, error) {
+	if opts.PretendVersion != nil && !opts.DryRun {
+		return nil, fmt.Errorf("cannot pretend the current version without DryRun")
+	}
+
+	if opts.ContinueOnError && m.TxMode == TxAlways {
+		return nil, fmt.Errorf("cannot continue on error when all migrations run in one transaction")
+	}
+
+	if opts.AllowDirty && m.Compat == CompatGolangMigrate {
+		return nil, fmt.Errorf("cannot allow a dirty state with golang-migrate compatibility, because the state does not say which migration failed")
+	}
+
+	if opts.OutOfOrder && (m.TxMode == TxAlways || opts.AllowDirty || opts.PretendVersion != nil) {
+		return nil, fmt.Errorf("cannot run migrations out of order with TxAlways, AllowDirty, or PretendVersion")
+	}
+
+	if opts.Savepoints {
+		if !supportsSavepoints(m.Driver) {
+			return nil, fmt.Errorf("savepoints are not supported on %s", m.Driver)
+		}
+
+		if !m.inTx() {
+			return nil, fmt.Errorf("savepoints require migrations to run in a transaction")
+		}
+	}
+
+	migrations, err := listMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	// by default, migrate all the way to the latest migration
+	var target int64
+	if len(migrations) > 0 {
+		target = migrations[len(migrations)-1].version
+	}
+
+	if opts.To != 0 {
+		target = opts.To
+		if !hasMigration(migrations, target) {
+			return nil, fmt.Errorf("no migration with target version: %d", target)
+		}
+	}
+
+	var results []MigrationResult
+
+	// applied migrations only need to be verified once, not once per segment
+	var verified bool
+
+	// total is the number of pending migrations, as of the first segment
+	var total int
+
+	// failures are the migrations that failed with opts.ContinueOnError set.
+	// The state does not advance past them, so lastFailed is the version of
+	// the last of them, up to which migrations are not tried again.
+	var failures MigrationErrors
+	var lastFailed int64
+
+	// each migration gets its own segment with opts.ContinueOnError, so that
+	// a failure only rolls back that migration
+	perMigration := m.TxMode == TxPerMigration || opts.ContinueOnError
+
+	if opts.OutOfOrder {
+		outOfOrder, pending, err := m.outOfOrderMigrations(ctx, migrations, target, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		// number the pending migrations after the out-of-order ones
+		if len(outOfOrder) > 0 {
+			total = len(outOfOrder) + pending
+		}
+
+		for _, i := range outOfOrder {
+			result, runErr, err := m.runOutOfOrder(ctx, &migrations[i], len(results)+1, total, opts)
+			if err != nil {
+				return results, err
+			}
+
+			if runErr != nil && !opts.ContinueOnError {
+				return results, runErr
+			}
+
+			if runErr != nil {
+				runFailed := &MigrationError{Migration: migrations[i].public(), Err: runErr}
+				m.logFailure(runFailed, len(results)+1, total)
+				failures = append(failures, runFailed)
+				result.Err = runErr
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	// Migrations that must not run in a transaction split the list of pending
+	// migrations into segments, as does TxPerMigration. Each segment runs in
+	// its own transaction, and the migrations between them run without one.
+	for {
+		var done bool
+		var segment []MigrationResult
+
+		// failed is the error of a migration that was rolled back to its
+		// savepoint, after which the segment is committed
+		var failed error
+
+		// runFailed is the migration that failed with opts.ContinueOnError
+		// set, if any, which ends the segment
+		var runFailed *MigrationError
+		if err := m.withTx(ctx, func(q queryer) error {
+			// the transaction may be retried, so start afresh each attempt
+			segment = nil
+			failed = nil
+			runFailed = nil
+
+			state, err := m.getState(ctx, q)
+			if err != nil {
+				return err
+			}
+
+			if opts.PretendVersion != nil {
+				state = State{Version: *opts.PretendVersion}
+			}
+
+			if state.Dirty {
+				if !opts.AllowDirty {
+					return fmt.Errorf("%w, will not migrate", ErrDirty)
+				}
+
+				prev := state
+				state.Dirty = false
+				if !opts.DryRun {
+					if err := m.updateState(ctx, q, prev, state); err != nil {
+						return err
+					}
+				}
+			}
+
+			if err := m.initChecksums(ctx, q); err != nil {
+				return err
+			}
+
+			if err := m.initHistory(ctx, q); err != nil {
+				return err
+			}
+
+			if !opts.NoVerify && !verified {
+				// only the checksums of applied migrations are needed to
+				// verify them, so avoid keeping their queries in memory
+				if err := loadMigrations(migrations, loadConcurrency, func(mig *migration) error {
+					if mig.version > state.Version {
+						return nil
+					}
+
+					return mig.loadChecksum(m.Migrations)
+				}); err != nil {
+					return err
+				}
+
+				if err := m.verifyChecksums(ctx, q, migrations, state.Version); err != nil {
+					return err
+				}
+
+				verified = true
+			}
+
+			if opts.To != 0 && target < state.Version {
+				return fmt.Errorf("target version %d is below current version %d, roll back with down migrations instead", target, state.Version)
+			}
+
+			// advance to first migration after current state, and after
+			// any that failed
+			var i int
+			for i < len(migrations) && (migrations[i].version <= state.Version || migrations[i].version <= lastFailed) {
+				i++
+			}
+
+			if total == 0 {
+				for j := i; j < len(migrations) && migrations[j].version <= target; j++ {
+					total++
+				}
+			}
+
+			// run all migrations thereafter, up to the target
+			for i < len(migrations) && migrations[i].version <= target {
+				if err := migrations[i].loadQuery(m.Migrations, m.parseOptions()); err != nil {
+					return err
+				}
+
+				if migrations[i].noTx && !migrations[i].skip && m.inTx() {
+					if m.TxMode == TxAlways {
+						return fmt.Errorf("migration %q cannot be run in a transaction, but transactional mode is always", migrations[i].name)
+					}
+
+					if !opts.DryRun {
+						// end this segment; the migration is run below
+						return nil
+					}
+				}
+
+				m.log(Event{
+					Migration: migrations[i].public(),
+					Position:  len(results) + len(segment) + 1,
+					Total:     total,
+					DryRun:    opts.DryRun,
+					Skipped:   migrations[i].skip,
+				})
+
+				result := MigrationResult{Version: migrations[i].version, Name: migrations[i].name, Skipped: migrations[i].skip}
+				if !opts.DryRun {
+					var duration time.Duration
+					run := func() error {
+						var err error
+						duration, err = m.runUp(ctx, q, state, migrations[i])
+						return err
+					}
+
+					if !opts.Savepoints {
+						if err := run(); err != nil {
+							if opts.ContinueOnError {
+								runFailed = &MigrationError{Migration: migrations[i].public(), Err: err}
+								if !m.inTx() {
+									// there is nothing to roll back, so
+									// undo marking the state dirty
+									if err := m.updateState(ctx, q, m.dirtyState(state, migrations[i].version, ""), state); err != nil {
+										return err
+									}
+
+									return nil
+								}
+							}
+
+							return err
+						}
+					} else {
+						runErr, err := m.withSavepoint(ctx, q, run)
+						if err != nil {
+							return err
+						}
+
+						if runErr != nil && opts.ContinueOnError {
+							runFailed = &MigrationError{Migration: migrations[i].public(), Err: runErr}
+							return nil
+						}
+
+						if runErr != nil {
+							// commit the migrations before this one
+							failed = runErr
+							done = true
+							return nil
+						}
+					}
+
+					state.Version = migrations[i].version
+					result.Applied = !migrations[i].skip
+					result.Duration = duration
+				}
+
+				segment = append(segment, result)
+				i++
+
+				// end this segment, so each migration gets its own
+				// transaction
+				if perMigration && !opts.DryRun {
+					return nil
+				}
+			}
+
+			done = true
+			return nil
+		}); err != nil && (runFailed == nil || !errors.Is(err, runFailed.Err)) {
+			return results, err
+		}
+
+		results = append(results, segment...)
+		if runFailed != nil {
+			m.logFailure(runFailed, len(results)+1, total)
+			results = append(results, MigrationResult{Version: runFailed.Migration.Version, Name: runFailed.Migration.Name, Err: runFailed.Err})
+			failures = append(failures, runFailed)
+			lastFailed = runFailed.Migration.Version
+			continue
+		}
+
+		if done && failures != nil {
+			return results, failures
+		}
+
+		if done {
+			return results, failed
+		}
+
+		// the segment ended after a migration, rather than before one that
+		// must run outside of a transaction
+		if perMigration && len(segment) > 0 {
+			continue
+		}
+
+		// run the migration that ended the segment outside of a transaction
+		if err := withTx(ctx, m.debugLog(), false, m.DB, func(q queryer) error {
+			state, err := m.getState(ctx, q)
+			if err != nil {
+				return err
+			}
+
+			var i int
+			for i < len(migrations) && (migrations[i].version <= state.Version || migrations[i].version <= lastFailed) {
+				i++
+			}
+
+			// the state has moved past the migration since the segment
+			// ended, so the next segment finds there is nothing left to run
+			if i == len(migrations) || migrations[i].version > target {
+				return nil
+			}
+
+			if err := migrations[i].loadQuery(m.Migrations, m.parseOptions()); err != nil {
+				return err
+			}
+
+			m.log(Event{Migration: migrations[i].public(), Position: len(results) + 1, Total: total})
+			duration, err := m.runUp(ctx, q, state, migrations[i])
+			if err != nil && opts.ContinueOnError {
+				runFailed := &MigrationError{Migration: migrations[i].public(), Err: err}
+				m.logFailure(runFailed, len(results)+1, total)
+				results = append(results, MigrationResult{Version: migrations[i].version, Name: migrations[i].name, Err: err})
+				failures = append(failures, runFailed)
+				lastFailed = migrations[i].version
+
+				// undo marking the state dirty
+				return m.updateState(ctx, q, m.dirtyState(state, migrations[i].version, ""), state)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			results = append(results, MigrationResult{
+				Version:  migrations[i].version,
+				Name:     migrations[i].name,
+				Applied:  true,
+				Duration: duration,
+			})
+
+			return nil
+		}); err != nil {
+			return results, err
+		}
+	}
+}
+
+func (m *Migrator) migrateWithoutState(ctx context.Context, opts MigrateOptions) ([]MigrationResult, error) {
+	if opts.AllowDirty || opts.Savepoints || opts.PretendVersion != nil || opts.OutOfOrder {
+		return nil, fmt.Errorf("cannot run migrations without state with AllowDirty, Savepoints, PretendVersion, or OutOfOrder")
+	}
+
+	if opts.ContinueOnError && m.TxMode == TxAlways {
+		return nil, fmt.Errorf("cannot continue on error when all migrations run in one transaction")
+	}
+
+	migrations, err := listMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.To != 0 && !hasMigration(migrations, opts.To) {
+		return nil, fmt.Errorf("no migration with target version: %d", opts.To)
+	}
+
+	if opts.To != 0 {
+		for i := range migrations {
+			if migrations[i].version > opts.To {
+				migrations = migrations[:i]
+				break
+			}
+		}
+	}
+
+	var results []MigrationResult
+	var failures MigrationErrors
+	for i := range migrations {
+		mig := &migrations[i]
+
+		if err := mig.loadQuery(m.Migrations, m.parseOptions()); err != nil {
+			return results, err
+		}
+
+		m.log(Event{Migration: mig.public(), Position: i + 1, Total: len(migrations), DryRun: opts.DryRun, Skipped: mig.skip})
+
+		result := MigrationResult{Version: mig.version, Name: mig.name, Skipped: mig.skip}
+		if !opts.DryRun && !mig.skip {
+			if err := m.withTxFor(ctx, *mig, func(q queryer) error {
+				if m.BeforeEach != nil {
+					if err := m.BeforeEach(ctx, mig.public()); err != nil {
+						return fmt.Errorf("before %q: %w", mig.name, err)
+					}
+				}
+
+				start := time.Now()
+				if err := m.execUp(ctx, q, *mig); err != nil {
+					return fmt.Errorf("exec %q: %w", mig.name, err)
+				}
+
+				result.Duration = time.Since(start)
+
+				if m.AfterEach != nil {
+					if err := m.AfterEach(ctx, mig.public()); err != nil {
+						return fmt.Errorf("after %q: %w", mig.name, err)
+					}
+				}
+
+				return nil
+			}); err != nil && opts.ContinueOnError {
+				failure := &MigrationError{Migration: mig.public(), Err: err}
+				m.logFailure(failure, i+1, len(migrations))
+				failures = append(failures, failure)
+				result.Err = err
+			} else if err != nil {
+				return results, err
+			} else {
+				result.Applied = true
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	if failures != nil {
+		return results, failures
+	}
+
+	return results, nil
+}
+
+// DownOptions are options for Down.
+type DownOptions struct {
+	// DryRun, if true, prevents any migrations from being rolled back.
+	DryRun bool
+
+	// Count is the number of migrations to roll back. Zero means 1, unless To
+	// or All is set.
+	Count int
+
+	// To, if nonzero, is the version to roll back to: every applied migration
+	// with a greater version is rolled back. There must be a migration with
+	// that version, and it must not be after the current version. To and
+	// Count may not both be set.
+	To int64
+
+	// All, if true, rolls back every applied migration, leaving the database
+	// at version 0. This is the opposite of migrating from scratch, and is
+	// useful for tearing down databases, such as in tests. Every applied
+	// migration must have a down migration. All may not be combined with To
+	// or Count.
+	All bool
+}
+
+// Down runs the down migrations of the most recently applied migrations, in
+// reverse version order. It prints the name of each migration it rolls back,
+// or would roll back if opts.DryRun is set.
+func (m *Migrator) Down(ctx context.Context, opts DownOptions) error {
+	return m.withLock(ctx, func() error {
+		return m.down(ctx, opts)
+	})
+}
+
+func (m *Migrator) down(ctx context.Context, opts DownOptions) error {
+	if opts.To != 0 && opts.Count != 0 {
+		return fmt.Errorf("cannot roll back both to a version and by a count")
+	}
+
+	if opts.All && (opts.To != 0 || opts.Count != 0) {
+		return fmt.Errorf("cannot roll back all migrations and to a version or by a count")
+	}
+
+	count := opts.Count
+	if count == 0 {
+		count = 1
+	}
+
+	migrations, err := parseMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return err
+	}
+
+	if opts.To != 0 && !hasMigration(migrations, opts.To) {
+		return fmt.Errorf("no migration with target version: %d", opts.To)
+	}
+
+	return m.withTx(ctx, func(q queryer) error {
+		state, err := m.getState(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		if state.Dirty {
+			return fmt.Errorf("%w, will not roll back", ErrDirty)
+		}
+
+		if err := m.initChecksums(ctx, q); err != nil {
+			return err
+		}
+
+		// find the last migration at or before current state
+		i := len(migrations) - 1
+		for i >= 0 && migrations[i].version > state.Version {
+			i--
+		}
+
+		if opts.To != 0 {
+			if opts.To > state.Version {
+				return fmt.Errorf("target version %d is above current version %d, apply migrations with migrate instead", opts.To, state.Version)
+			}
+
+			// roll back every migration after the target
+			count = 0
+			for j := i; migrations[j].version > opts.To; j-- {
+				count++
+			}
+		}
+
+		if opts.All {
+			count = i + 1
+		}
+
+		if count > i+1 {
+			return fmt.Errorf("cannot roll back %d migrations, only %d have been applied", count, i+1)
+		}
+
+		for j := i; j > i-count; j-- {
+			if migrations[j].downQuery == "" && !migrations[j].skip {
+				return fmt.Errorf("migration has no down migration: %q", migrations[j].name)
+			}
+		}
+
+		// run down migrations in reverse order
+		for j := i; j > i-count; j-- {
+			m.log(Event{Migration: migrations[j].public(), Down: true, DryRun: opts.DryRun, Skipped: migrations[j].skip})
+
+			if !opts.DryRun {
+				var prevVersion int64
+				if j > 0 {
+					prevVersion = migrations[j-1].version
+				}
+
+				if err := m.runDown(ctx, q, state, migrations[j], prevVersion); err != nil {
+					return err
+				}
+
+				state.Version = prevVersion
+			}
+		}
+
+		return nil
+	})
+}
+
+// ApplyOptions are options for Apply.
+type ApplyOptions struct {
+	// SetVersion, if true, makes Apply record the migration as applied, by
+	// setting the state's version to the migration's version. The migration
+	// must then be newer than the current version.
+	SetVersion bool
+}
+
+// Apply runs the migration with the given version, regardless of the current
+// version, and without running any other migration. It prints the name of the
+// migration as it runs it, and records it and its checksum in the history and
+// checksum tables.
+//
+// Unless opts.SetVersion is set, Apply does not change the state, and so Apply
+// does not make the state dirty if the migration fails. Apply is meant as an
+// escape hatch for hotfixes, because it makes the migrations applied to the
+// database differ from what the state says.
+func (m *Migrator) Apply(ctx context.Context, version int64, opts ApplyOptions) error {
+	return m.withLock(ctx, func() error {
+		return m.apply(ctx, version, opts)
+	})
+}
+
+func (m *Migrator) apply(ctx context.Context, version int64, opts ApplyOptions) error {
+	migrations, err := listMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return err
+	}
+
+	i := 0
+	for i < len(migrations) && migrations[i].version != version {
+		i++
+	}
+
+	if i == len(migrations) {
+		return fmt.Errorf("no migration with version: %d", version)
+	}
+
+	mig := migrations[i]
+	if err := mig.loadQuery(m.Migrations, m.parseOptions()); err != nil {
+		return err
+	}
+
+	return m.withTxFor(ctx, mig, func(q queryer) error {
+		state, err := m.getState(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		if state.Dirty {
+			return fmt.Errorf("%w, will not apply", ErrDirty)
+		}
+
+		if opts.SetVersion && mig.version <= state.Version {
+			return fmt.Errorf("migration version %d is not above current version %d, so cannot set version to it", mig.version, state.Version)
+		}
+
+		if err := m.initChecksums(ctx, q); err != nil {
+			return err
+		}
+
+		if err := m.initHistory(ctx, q); err != nil {
+			return err
+		}
+
+		m.log(Event{Migration: mig.public(), Skipped: mig.skip})
+		if opts.SetVersion {
+			_, err := m.runUp(ctx, q, state, mig)
+			return err
+		}
+
+		// the checksum records that the migration was applied, so that
+		// MigrateOptions.OutOfOrder does not run it again
+		if mig.skip {
+			return m.setChecksum(ctx, q, mig)
+		}
+
+		appliedAt, start := m.now(), time.Now()
+		if err := m.execUp(ctx, q, mig); err != nil {
+			return fmt.Errorf("exec %q: %w", mig.name, err)
+		}
+
+		duration := time.Since(start)
+		if err := m.setChecksum(ctx, q, mig); err != nil {
+			return err
+		}
+
+		return m.insertHistory(ctx, q, mig, appliedAt, duration)
+	})
+}
+
+// RedoOptions are options for Redo.
+type RedoOptions struct {
+	// DryRun, if true, prevents the migration from being rolled back or
+	// re-applied.
+	DryRun bool
+}
+
+// Redo runs the down migration of the current version's migration, and then
+// runs its up migration again. It prints the name of the migration as it rolls
+// it back and re-applies it.
+func (m *Migrator) Redo(ctx context.Context, opts RedoOptions) error {
+	return m.withLock(ctx, func() error {
+		return m.redo(ctx, opts)
+	})
+}
+
+func (m *Migrator) redo(ctx context.Context, opts RedoOptions) error {
+	migrations, err := parseMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return err
+	}
+
+	return m.withTx(ctx, func(q queryer) error {
+		state, err := m.getState(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		if state.Dirty {
+			return fmt.Errorf("%w, will not redo", ErrDirty)
+		}
+
+		if err := m.initChecksums(ctx, q); err != nil {
+			return err
+		}
+
+		if err := m.initHistory(ctx, q); err != nil {
+			return err
+		}
+
+		if state.Version == 0 {
+			return fmt.Errorf("no migrations have been applied, nothing to redo")
+		}
+
+		// find the migration for the current state
+		i := len(migrations) - 1
+		for i >= 0 && migrations[i].version != state.Version {
+			i--
+		}
+
+		if i < 0 {
+			return fmt.Errorf("no migration for current version: %d", state.Version)
+		}
+
+		if migrations[i].downQuery == "" && !migrations[i].skip {
+			return fmt.Errorf("migration has no down migration: %q", migrations[i].name)
+		}
+
+		var prevVersion int64
+		if i > 0 {
+			prevVersion = migrations[i-1].version
+		}
+
+		m.log(Event{Migration: migrations[i].public(), Down: true, Redo: true, DryRun: opts.DryRun, Skipped: migrations[i].skip})
+		if !opts.DryRun {
+			if err := m.runDown(ctx, q, state, migrations[i], prevVersion); err != nil {
+				return err
+			}
+
+			state.Version = prevVersion
+		}
+
+		m.log(Event{Migration: migrations[i].public(), Redo: true, DryRun: opts.DryRun, Skipped: migrations[i].skip})
+		if !opts.DryRun {
+			if _, err := m.runUp(ctx, q, state, migrations[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Verify compares the database's state against the migrations, and returns a
+// description of each problem it finds. It reports:
+//
+// Applied migrations that have been modified since they were applied, per
+// their recorded checksums.
+//
+// Applied versions that have no corresponding migration.
+//
+// Migrations at or before the current version that have no recorded checksum,
+// even though a later migration does. Such migrations were likely added after
+// later migrations were applied, and so were never run.
+//
+// Each problem begins with its kind, followed by a colon. The kind of a modified
+// migration is the text of ErrChecksumMismatch.
+//
+// Verify does not modify the database, except to create the checksums table if
+// it does not already exist.
+func (m *Migrator) Verify(ctx context.Context) ([]string, error) {
+	migrations, err := parseMigrations(m.Migrations, m.parseOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	err = m.withTx(ctx, func(q queryer) error {
+		state, err := m.getState(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		if err := m.initChecksums(ctx, q); err != nil {
+			return err
+		}
+
+		checksums, err := m.getChecksums(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range modifiedMigrations(migrations, checksums, state.Version) {
+			problems = append(problems, fmt.Sprintf("%v: %q has been modified since it was applied", ErrChecksumMismatch, mig.name))
+		}
+
+		if state.Version != 0 && !hasMigration(migrations, state.Version) {
+			problems = append(problems, fmt.Sprintf("missing migration: current version is %d, but there is no migration with that version", state.Version))
+		}
+
+		var recorded []int64
+		for version := range checksums {
+			recorded = append(recorded, version)
+		}
+
+		sort.Slice(recorded, func(i, j int) bool { return recorded[i] < recorded[j] })
+		for _, version := range recorded {
+			if version != state.Version && !hasMigration(migrations, version) {
+				problems = append(problems, fmt.Sprintf("missing migration: version %d was applied, but there is no migration with that version", version))
+			}
+		}
+
+		// the latest version with a recorded checksum; unrecorded migrations
+		// before it were skipped
+		var lastRecorded int64
+		for _, mig := range migrations {
+			if _, ok := checksums[mig.version]; ok && mig.version <= state.Version {
+				lastRecorded = mig.version
+			}
+		}
+
+		for _, mig := range migrations {
+			if mig.version >= lastRecorded {
+				break
+			}
+
+			if _, ok := checksums[mig.version]; !ok {
+				problems = append(problems, fmt.Sprintf("gap: %q has no recorded checksum, but later migrations do; it may never have been applied", mig.name))
+			}
+		}
+
+		return nil
+	})
+
+	return problems, err
+}
+
+// runUp runs the up half of mig, marking s as dirty while doing so. Afterwards,
+// the state is clean and at mig's version, and mig has been appended to the
+// history table. It returns how long the up query took to run.
+//
+// s must be the current state. If the state is changed by another process
+// while runUp is running, runUp returns ErrStateChanged.
+//
+// If mig is skipped, nothing is run and no history is appended, but the state
+// is still advanced to mig's version, and mig's checksum is recorded.
+func (m *Migrator) runUp(ctx context.Context, q queryer, s State, mig migration) (time.Duration, error) {
+	return m.runUpTo(ctx, q, s, mig, mig.version)
+}
+
+// runUpTo is like runUp, except that afterwards the state is at version, rather
+// than mig's version.
+func (m *Migrator) runUpTo(ctx context.Context, q queryer, s State, mig migration, version int64) (time.Duration, error) {
+	if mig.skip {
+		if err := m.setChecksum(ctx, q, mig); err != nil {
+			return 0, err
+		}
+
+		return 0, m.updateState(ctx, q, s, State{Version: version, Dirty: false})
+	}
+
+	if m.BeforeEach != nil {
+		if err := m.BeforeEach(ctx, mig.public()); err != nil {
+			return 0, fmt.Errorf("before %q: %w", mig.name, err)
+		}
+	}
+
+	dirty := m.dirtyState(s, version, mig.name)
+	if err := m.updateState(ctx, q, s, dirty); err != nil {
+		return 0, err
+	}
+
+	appliedAt, start := m.now(), time.Now()
+	if err := m.execUp(ctx, q, mig); err != nil {
+		return 0, fmt.Errorf("exec %q: %w", mig.name, err)
+	}
+
+	duration := time.Since(start)
+
+	if m.AfterEach != nil {
+		if err := m.AfterEach(ctx, mig.public()); err != nil {
+			return 0, fmt.Errorf("after %q: %w", mig.name, err)
+		}
+	}
+
+	if err := m.setChecksum(ctx, q, mig); err != nil {
+		return 0, err
+	}
+
+	if err := m.insertHistory(ctx, q, mig, appliedAt, duration); err != nil {
+		return 0, err
+	}
+
+	return duration, m.updateState(ctx, q, dirty, State{Version: version, Dirty: false})
+}
+
+// exec runs the contents of a migration, with m.SearchPath in effect if set. If
+// m.SplitStatements is set, each statement is run separately.
+func (m *Migrator) exec(ctx context.Context, q queryer, query string) error {
+	return m.withSearchPath(ctx, q, func() error {
+		return m.execStatements(ctx, q, query)
+	})
+}
+
+// execUp runs the up half of mig, calling m.OnMigrationStart and
+// m.OnMigrationEnd around it.
+func (m *Migrator) execUp(ctx context.Context, q queryer, mig migration) error {
+	if m.OnMigrationStart != nil {
+		m.OnMigrationStart(mig.public())
+	}
+
+	err := m.exec(ctx, q, mig.upQuery)
+	if m.OnMigrationEnd != nil {
+		m.OnMigrationEnd(mig.public(), err)
+	}
+
+	return err
+}
+
+func (m *Migrator) execStatements(ctx context.Context, q queryer, query string) error {
+	// Goose always runs statements separately, so migrations written for it
+	// may rely on that
+	if !m.SplitStatements && m.MigrationFormat != FormatGoose {
+		_, err := q.ExecContext(ctx, query)
+		return err
+	}
+
+	for i, stmt := range m.statements(query) {
+		if _, err := q.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("statement %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// statements splits query, half of a migration, into its individual
+// statements.
+func (m *Migrator) statements(query string) []string {
+	if m.MigrationFormat == FormatGoose {
+		return splitGooseStatements(m.Driver, query)
+	}
+
+	return splitStatements(m.Driver, query)
+}
+
+// parseOptions returns the options to parse m's migrations with.
+func (m *Migrator) parseOptions() parseOptions {
+	return parseOptions{
+		namePattern:    m.NamePattern,
+		extensions:     m.Extensions,
+		expandEnv:      m.ExpandEnv,
+		templateData:   m.TemplateData,
+		injectMetadata: m.InjectMetadata,
+		driver:         m.Driver,
+		format:         m.MigrationFormat,
+	}
+}
+
+// now returns the current time, per m.Now if it is set.
+func (m *Migrator) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+
+	return time.Now()
+}
+
+// debugLog returns the log to write debugging information to.
+func (m *Migrator) debugLog() debugLog {
+	return debugLog{w: m.DebugOutput}
+}
+
+// log reports e to m.Logger, or if it is nil, to a TextLogger writing to
+// m.Output.
+func (m *Migrator) log(e Event) {
+	if m.Logger != nil {
+		m.Logger.Log(e)
+		return
+	}
+
+	output := m.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	TextLogger{Output: output}.Log(e)
+}
+
+// logFailure reports to m's logger that a migration failed, at position among
+// total migrations, when Migrate is continuing past failures.
+func (m *Migrator) logFailure(e *MigrationError, position, total int) {
+	m.log(Event{Migration: e.Migration, Position: position, Total: total, Err: e.Err})
+}
+
+// runDown runs the down half of mig, marking s as dirty while doing so.
+// Afterwards, the state is clean and at prevVersion. If mig is skipped, its up
+// half was never run, so neither is its down half. Like runUp, runDown returns
+// ErrStateChanged if s is changed by another process.
+func (m *Migrator) runDown(ctx context.Context, q queryer, s State, mig migration, prevVersion int64) error {
+	if mig.skip {
+		if err := m.deleteChecksum(ctx, q, mig.version); err != nil {
+			return err
+		}
+
+		return m.updateState(ctx, q, s, State{Version: prevVersion, Dirty: false})
+	}
+
+	name := mig.name
+	if mig.downName != "" {
+		name = mig.downName
+	}
+
+	dirty := m.dirtyState(s, prevVersion, name)
+	if err := m.updateState(ctx, q, s, dirty); err != nil {
+		return err
+	}
+
+	if err := m.exec(ctx, q, mig.downQuery); err != nil {
+		return fmt.Errorf("exec down %q: %w", mig.name, err)
+	}
+
+	if err := m.deleteChecksum(ctx, q, mig.version); err != nil {
+		return err
+	}
+
+	return m.updateState(ctx, q, dirty, State{Version: prevVersion, Dirty: false})
+}
+
+// withTx runs f against m.DB, in a transaction if m.TxMode calls for one.
+func (m *Migrator) withTx(ctx context.Context, f func(queryer) error) error {
+	if m.Driver == "cockroachdb" && m.inTx() {
+		attempts := m.TxAttempts
+		if attempts == 0 {
+			attempts = 3
+		}
+
+		return withTxRetries(ctx, m.debugLog(), attempts, isRetryableError, m.DB, f)
+	}
+
+	return withTx(ctx, m.debugLog(), m.inTx(), m.DB, f)
+}
+
+// withTxFor is like withTx, for an operation that runs mig, except that f is run
+// without a transaction if mig must not be run in one.
+func (m *Migrator) withTxFor(ctx context.Context, mig migration, f func(queryer) error) error {
+	if !mig.noTx || !m.inTx() {
+		return m.withTx(ctx, f)
+	}
+
+	if m.TxMode == TxAlways {
+		return fmt.Errorf("migration %q cannot be run in a transaction, but transactional mode is always", mig.name)
+	}
+
+	return withTx(ctx, m.debugLog(), false, m.DB, f)
+}
+
+// inTx returns whether m.TxMode calls for operations to run in a transaction.
+func (m *Migrator) inTx() bool {
+	switch m.TxMode {
+	case TxAlways, TxPerMigration:
+		return true
+	case TxNever:
+		return false
+	default:
+		switch m.Driver {
+		case "mysql", "clickhouse":
+			return false
+		default:
+			return true
+		}
+	}
+}
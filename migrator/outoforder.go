@@ -0,0 +1,138 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// outOfOrderMigrations returns the indexes in migrations, which must be sorted
+// by version, of the migrations up to target that are older than the current
+// version but have not been applied, per MigrateOptions.OutOfOrder. It also
+// returns how many migrations up to target are newer than the current version.
+func (m *Migrator) outOfOrderMigrations(ctx context.Context, migrations []migration, target int64, opts MigrateOptions) ([]int, int, error) {
+	var outOfOrder []int
+	var pending int
+	err := m.withTx(ctx, func(q queryer) error {
+		// the transaction may be retried, so start afresh each attempt
+		outOfOrder, pending = nil, 0
+
+		state, err := m.getState(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		if state.Dirty {
+			return fmt.Errorf("%w, will not migrate", ErrDirty)
+		}
+
+		if opts.To != 0 && target < state.Version {
+			return fmt.Errorf("target version %d is below current version %d, roll back with down migrations instead", target, state.Version)
+		}
+
+		if err := m.initChecksums(ctx, q); err != nil {
+			return err
+		}
+
+		checksums, err := m.getChecksums(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		// without any checksums, there is no telling which migrations
+		// were applied
+		if len(checksums) == 0 {
+			return nil
+		}
+
+		oldest := state.Version
+		for version := range checksums {
+			if version < oldest {
+				oldest = version
+			}
+		}
+
+		for i, mig := range migrations {
+			if mig.version > target {
+				break
+			}
+
+			if mig.version > state.Version {
+				pending++
+				continue
+			}
+
+			if _, ok := checksums[mig.version]; !ok && oldest < mig.version && mig.version < state.Version {
+				outOfOrder = append(outOfOrder, i)
+			}
+		}
+
+		return nil
+	})
+
+	return outOfOrder, pending, err
+}
+
+// runOutOfOrder runs mig, which is older than the current version, at position
+// among total migrations, without changing the state's version. Its checksum is
+// recorded, so that it is known to have been applied.
+//
+// runErr is non-nil if mig itself failed, and was rolled back if it ran in a
+// transaction. err is non-nil if anything else failed.
+func (m *Migrator) runOutOfOrder(ctx context.Context, mig *migration, position, total int, opts MigrateOptions) (result MigrationResult, runErr, err error) {
+	if err := mig.loadQuery(m.Migrations, m.parseOptions()); err != nil {
+		return MigrationResult{}, nil, err
+	}
+
+	m.log(Event{
+		Migration:  mig.public(),
+		Position:   position,
+		Total:      total,
+		DryRun:     opts.DryRun,
+		Skipped:    mig.skip,
+		OutOfOrder: true,
+	})
+
+	result = MigrationResult{Version: mig.version, Name: mig.name, Skipped: mig.skip, OutOfOrder: true}
+	if opts.DryRun {
+		return result, nil, nil
+	}
+
+	err = m.withTxFor(ctx, *mig, func(q queryer) error {
+		// the transaction may be retried, so start afresh each attempt
+		runErr = nil
+
+		state, err := m.getState(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		var duration time.Duration
+		if duration, runErr = m.runUpTo(ctx, q, state, *mig, state.Version); runErr != nil {
+			if opts.ContinueOnError && (mig.noTx || !m.inTx()) {
+				// there is nothing to roll back, so undo marking the state
+				// dirty
+				return m.updateState(ctx, q, m.dirtyState(state, state.Version, ""), state)
+			}
+
+			return runErr
+		}
+
+		result.Duration = duration
+		return nil
+	})
+
+	// the migration failing rolls back its transaction, which is not
+	// otherwise an error
+	if runErr != nil && errors.Is(err, runErr) {
+		err = nil
+	}
+
+	if err != nil || runErr != nil {
+		return result, runErr, err
+	}
+
+	result.Applied = !mig.skip
+	return result, nil, nil
+}
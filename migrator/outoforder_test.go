@@ -0,0 +1,82 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMigrateOutOfOrderAfterApply(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	migrations := fstest.MapFS{
+		"1_one.sql":   {Data: []byte("create table one (x int);")},
+		"3_three.sql": {Data: []byte("create table three (x int);")},
+	}
+
+	m := &Migrator{
+		DB:         db,
+		Driver:     "sqlite3",
+		StateTable: "sqlcc_state",
+		Migrations: migrations,
+		Output:     io.Discard,
+	}
+
+	if err := m.Init(ctx, InitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Migrate(ctx, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 and 4 are merged after 3 was applied, and 2 is hotfixed with apply,
+	// which must not cause it to be run again, since it would fail
+	migrations["2_two.sql"] = &fstest.MapFile{Data: []byte("create table two (x int);")}
+	migrations["4_four.sql"] = &fstest.MapFile{Data: []byte("create table four (x int);")}
+
+	if err := m.Apply(ctx, 2, ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := m.Migrate(ctx, MigrateOptions{OutOfOrder: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].Version != 4 || results[0].OutOfOrder || !results[0].Applied {
+		t.Fatalf("want only 4 to be applied, got: %+v", results)
+	}
+
+	state, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.Version != 4 || state.Dirty {
+		t.Fatalf("want state at version 4, got: %+v", state)
+	}
+
+	checksums, err := m.getChecksums(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []int64{1, 2, 3, 4} {
+		if _, ok := checksums[version]; !ok {
+			t.Errorf("no checksum recorded for version %d", version)
+		}
+	}
+}
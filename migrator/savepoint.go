@@ -0,0 +1,63 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+)
+
+// savepointName is the name of the savepoint each migration is run within when
+// MigrateOptions.Savepoints is set. Each savepoint is released before the next
+// is created, so the name can be reused.
+const savepointName = "sqlcc_migration"
+
+// These are the statements that create, release, and roll back to a savepoint,
+// for each driver that supports savepoints. SQL Server has no way to release a
+// savepoint; its savepoints last until the transaction ends.
+const (
+	savepointSQL         = `savepoint %s`
+	releaseSavepointSQL  = `release savepoint %s`
+	rollbackSavepointSQL = `rollback to savepoint %s`
+
+	savepointSQLSQLServer         = `save transaction %s`
+	rollbackSavepointSQLSQLServer = `rollback transaction %s`
+)
+
+// supportsSavepoints returns whether driver supports savepoints.
+func supportsSavepoints(driver string) bool {
+	return driver != "clickhouse"
+}
+
+// withSavepoint runs f within a savepoint of the transaction q is part of. If f
+// fails, the transaction is rolled back to the savepoint, undoing only what f
+// did, and f's error is returned as fErr.
+//
+// err is non-nil if a savepoint statement itself failed, in which case the
+// transaction must be rolled back entirely.
+func (m *Migrator) withSavepoint(ctx context.Context, q queryer, f func() error) (fErr, err error) {
+	create, release, rollback := savepointSQL, releaseSavepointSQL, rollbackSavepointSQL
+	if m.Driver == "sqlserver" {
+		create, release, rollback = savepointSQLSQLServer, "", rollbackSavepointSQLSQLServer
+	}
+
+	if _, err := q.ExecContext(ctx, fmt.Sprintf(create, savepointName)); err != nil {
+		return nil, fmt.Errorf("create savepoint: %w", err)
+	}
+
+	if fErr := f(); fErr != nil {
+		if _, err := q.ExecContext(ctx, fmt.Sprintf(rollback, savepointName)); err != nil {
+			return nil, fmt.Errorf("rollback to savepoint: %w (after error: %v)", err, fErr)
+		}
+
+		return fErr, nil
+	}
+
+	if release == "" {
+		return nil, nil
+	}
+
+	if _, err := q.ExecContext(ctx, fmt.Sprintf(release, savepointName)); err != nil {
+		return nil, fmt.Errorf("release savepoint: %w", err)
+	}
+
+	return nil, nil
+}
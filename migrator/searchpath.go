@@ -0,0 +1,106 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// These are the statements that set and reset the search path, for each driver
+// that supports one. MySQL has no search path, but a default database, which
+// cannot be reset, only changed back.
+const (
+	setSearchPathSQLPostgres   = `set search_path to %s`
+	resetSearchPathSQLPostgres = `reset search_path`
+
+	getSearchPathSQLMySQL = `select database()`
+	setSearchPathSQLMySQL = `use %s`
+)
+
+// ValidateSearchPath checks that searchPath can be used with driver.
+func ValidateSearchPath(driver string, searchPath []string) error {
+	for _, schema := range searchPath {
+		if schema == "" {
+			return fmt.Errorf("schema names must not be empty")
+		}
+	}
+
+	switch driver {
+	case "postgres", "cockroachdb", "sqlite3", "sqlite":
+		return nil
+	case "mysql":
+		if len(searchPath) > 1 {
+			return fmt.Errorf("must be a single database for mysql")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("not supported for %s", driver)
+	}
+}
+
+// withSearchPath runs f, which runs statements against q, with m.SearchPath in
+// effect, and then restores the search path q had before. If m.SearchPath is
+// not set, or the driver has no search path, withSearchPath just runs f.
+//
+// If f fails, withSearchPath still tries to restore the search path, for when q
+// is not a transaction whose rollback would restore it.
+func (m *Migrator) withSearchPath(ctx context.Context, q queryer, f func() error) error {
+	if len(m.SearchPath) == 0 {
+		return f()
+	}
+
+	if err := ValidateSearchPath(m.Driver, m.SearchPath); err != nil {
+		return fmt.Errorf("invalid search path: %w", err)
+	}
+
+	var set, reset string
+	switch m.Driver {
+	case "postgres", "cockroachdb":
+		var schemas []string
+		for _, schema := range m.SearchPath {
+			schemas = append(schemas, quoteIdent(m.Driver, schema))
+		}
+
+		set, reset = fmt.Sprintf(setSearchPathSQLPostgres, strings.Join(schemas, ", ")), resetSearchPathSQLPostgres
+	case "mysql":
+		var prev sql.NullString
+		if err := q.QueryRowContext(ctx, getSearchPathSQLMySQL).Scan(&prev); err != nil {
+			return fmt.Errorf("get search path: %w", err)
+		}
+
+		set = fmt.Sprintf(setSearchPathSQLMySQL, quoteIdent(m.Driver, m.SearchPath[0]))
+
+		// with no database to go back to, the one given stays in use for
+		// the rest of the session
+		if prev.Valid {
+			reset = fmt.Sprintf(setSearchPathSQLMySQL, quoteIdent(m.Driver, prev.String))
+		}
+	default:
+		// sqlite has no schemas to search
+		return f()
+	}
+
+	if _, err := q.ExecContext(ctx, set); err != nil {
+		return fmt.Errorf("set search path: %w", err)
+	}
+
+	if err := f(); err != nil {
+		if reset != "" {
+			_, _ = q.ExecContext(ctx, reset)
+		}
+
+		return err
+	}
+
+	if reset == "" {
+		return nil
+	}
+
+	if _, err := q.ExecContext(ctx, reset); err != nil {
+		return fmt.Errorf("reset search path: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package migrator
+
+import (
+	"strings"
+)
+
+// splitStatements splits query into its individual statements, on semicolons
+// that are not inside a string, quoted identifier, comment, or dollar-quoted
+// string. Statements consisting of only whitespace and comments are omitted.
+//
+// On MySQL, a backslash inside a quoted string escapes the character after it.
+// Other databases follow standard SQL, where backslashes are not special.
+// Dollar-quoted strings are only recognized on Postgres and CockroachDB.
+func splitStatements(driver, query string) []string {
+	backslashEscapes := driver == "mysql"
+	dollarQuotes := driver == "postgres" || driver == "cockroachdb"
+
+	var statements []string
+	var start int        // where the current statement begins
+	var hasCode bool     // whether the current statement has any non-comment content
+	var quote byte       // the quote character of the string being scanned, if any
+	var dollarTag string // the tag of the dollar-quoted string being scanned, if any
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' && backslashEscapes {
+				i++
+			} else if c == quote {
+				// a doubled quote is an escaped quote, and the string
+				// continues
+				if i+1 < len(query) && query[i+1] == quote {
+					i++
+				} else {
+					quote = 0
+				}
+			}
+		case dollarTag != "":
+			if strings.HasPrefix(query[i:], dollarTag) {
+				i += len(dollarTag) - 1
+				dollarTag = ""
+			}
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if end := strings.IndexByte(query[i:], '\n'); end == -1 {
+				i = len(query)
+			} else {
+				i += end
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end == -1 {
+				i = len(query)
+			} else {
+				i += 2 + end + 1
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			hasCode = true
+		case c == '$':
+			hasCode = true
+
+			// a dollar sign within an identifier does not begin a
+			// dollar-quoted string
+			if !dollarQuotes || (i > 0 && isIdentChar(query[i-1])) {
+				continue
+			}
+
+			if tag := dollarQuoteTag(query[i:]); tag != "" {
+				dollarTag = tag
+				i += len(tag) - 1
+			}
+		case c == ';':
+			if hasCode {
+				statements = append(statements, strings.TrimSpace(query[start:i]))
+			}
+
+			start = i + 1
+			hasCode = false
+		case !isSpace(c):
+			hasCode = true
+		}
+	}
+
+	if hasCode {
+		statements = append(statements, strings.TrimSpace(query[start:]))
+	}
+
+	return statements
+}
+
+// dollarQuoteTag returns the dollar-quote tag that s begins with, like "$$" or
+// "$body$", or the empty string if s does not begin with one.
+func dollarQuoteTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+
+		// tags may not begin with a digit, so that "$1" is a parameter
+		if !isIdentChar(c) || (i == 1 && '0' <= c && c <= '9') {
+			return ""
+		}
+	}
+
+	return ""
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
@@ -0,0 +1,174 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		driver string
+		query  string
+		want   []string
+	}{
+		{
+			"simple",
+			"postgres",
+			"create table a (x int);\ncreate table b (x int);",
+			[]string{"create table a (x int)", "create table b (x int)"},
+		},
+		{
+			"no trailing semicolon",
+			"sqlite3",
+			"select 1; select 2",
+			[]string{"select 1", "select 2"},
+		},
+		{
+			"semicolon in single quotes",
+			"postgres",
+			"insert into a values ('x;y'); select 1;",
+			[]string{"insert into a values ('x;y')", "select 1"},
+		},
+		{
+			"doubled single quote",
+			"postgres",
+			"insert into a values ('it''s; fine'); select 1;",
+			[]string{"insert into a values ('it''s; fine')", "select 1"},
+		},
+		{
+			"semicolon in double-quoted identifier",
+			"postgres",
+			`create table "a;b" ("x"";y" int); select 1;`,
+			[]string{`create table "a;b" ("x"";y" int)`, "select 1"},
+		},
+		{
+			"semicolon in backquoted identifier",
+			"mysql",
+			"create table `a;b` (x int); select 1;",
+			[]string{"create table `a;b` (x int)", "select 1"},
+		},
+		{
+			"backslash escape on mysql",
+			"mysql",
+			`insert into a values ('x\'; y'); select 1;`,
+			[]string{`insert into a values ('x\'; y')`, "select 1"},
+		},
+		{
+			"backslash is not an escape on postgres",
+			"postgres",
+			`insert into a values ('x\'); select 1;`,
+			[]string{`insert into a values ('x\')`, "select 1"},
+		},
+		{
+			"backslash is not an escape on sqlite",
+			"sqlite3",
+			`insert into a values ('x\'); select 1;`,
+			[]string{`insert into a values ('x\')`, "select 1"},
+		},
+		{
+			"backslash is not an escape on sqlserver",
+			"sqlserver",
+			`insert into a values ('x\'); select 1;`,
+			[]string{`insert into a values ('x\')`, "select 1"},
+		},
+		{
+			"semicolon in line comment",
+			"postgres",
+			"select 1; -- a; b\nselect 2;",
+			[]string{"select 1", "-- a; b\nselect 2"},
+		},
+		{
+			"semicolon in block comment",
+			"mysql",
+			"select 1 /* a; b */; select 2;",
+			[]string{"select 1 /* a; b */", "select 2"},
+		},
+		{
+			"quote in comment",
+			"postgres",
+			"-- it's\nselect 1; /* don't */ select 2;",
+			[]string{"-- it's\nselect 1", "/* don't */ select 2"},
+		},
+		{
+			"dollar quotes",
+			"postgres",
+			"create function f() returns int as $$ select 1; $$ language sql; select 2;",
+			[]string{"create function f() returns int as $$ select 1; $$ language sql", "select 2"},
+		},
+		{
+			"tagged dollar quotes",
+			"cockroachdb",
+			"select $body$ a; $$ b; $body$; select 2;",
+			[]string{"select $body$ a; $$ b; $body$", "select 2"},
+		},
+		{
+			"dollar quotes only on postgres and cockroachdb",
+			"mysql",
+			"select '$$'; select $$; select 2;",
+			[]string{"select '$$'", "select $$", "select 2"},
+		},
+		{
+			"parameter is not a dollar quote",
+			"postgres",
+			"prepare p as select $1; select 2;",
+			[]string{"prepare p as select $1", "select 2"},
+		},
+		{
+			"dollar in identifier is not a dollar quote",
+			"postgres",
+			"select a$b$ from t; select 2;",
+			[]string{"select a$b$ from t", "select 2"},
+		},
+		{
+			"unterminated block comment",
+			"postgres",
+			"select 1; /* a; b",
+			[]string{"select 1"},
+		},
+		{
+			"whitespace-only statements are dropped",
+			"postgres",
+			"select 1;\n\n ;\t; select 2;\n\n",
+			[]string{"select 1", "select 2"},
+		},
+		{
+			"comment-only statements are dropped",
+			"postgres",
+			"select 1; -- done\n/* really; done */;\n-- the end\n",
+			[]string{"select 1"},
+		},
+		{
+			"empty",
+			"sqlite3",
+			"",
+			nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.driver, tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements(%q, %q):\ngot:  %q\nwant: %q", tt.driver, tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDollarQuoteTag(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want string
+	}{
+		{"$$ select 1", "$$"},
+		{"$body$ select 1", "$body$"},
+		{"$_1$ select 1", "$_1$"},
+		{"$1", ""},
+		{"$1$", ""},
+		{"$a b$", ""},
+		{"$abc", ""},
+		{"$", ""},
+	} {
+		if got := dollarQuoteTag(tt.s); got != tt.want {
+			t.Errorf("dollarQuoteTag(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
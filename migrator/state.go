@@ -0,0 +1,512 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var stateTableNamePattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*\.)?[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateStateTable checks that stateTable is a plain SQL identifier,
+// optionally qualified by a schema name. Because the state table name is
+// interpolated into SQL, anything else is rejected.
+func ValidateStateTable(stateTable string) error {
+	if !stateTableNamePattern.MatchString(stateTable) {
+		return fmt.Errorf("must be of the form table_name or schema_name.table_name, using only letters, digits, and underscores: %q", stateTable)
+	}
+
+	return nil
+}
+
+var stateSchemaNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateStateSchema checks that stateSchema is a plain SQL identifier, and
+// that stateTable, which is to be qualified by it, does not have a schema of
+// its own.
+func ValidateStateSchema(stateSchema, stateTable string) error {
+	if !stateSchemaNamePattern.MatchString(stateSchema) {
+		return fmt.Errorf("must use only letters, digits, and underscores: %q", stateSchema)
+	}
+
+	if strings.Contains(stateTable, ".") {
+		return fmt.Errorf("state table must not also include a schema: %q", stateTable)
+	}
+
+	return nil
+}
+
+// ValidateStateColumn checks that column, the name of a column of the state
+// table, is a plain SQL identifier. Because column names are interpolated into
+// SQL, anything else is rejected.
+func ValidateStateColumn(column string) error {
+	if !stateSchemaNamePattern.MatchString(column) {
+		return fmt.Errorf("must use only letters, digits, and underscores, and may not begin with a digit: %q", column)
+	}
+
+	return nil
+}
+
+// These are the names of the state table's version and dirty columns, unless
+// StateVersionColumn or StateDirtyColumn are set.
+const (
+	defaultStateVersionColumn = "version"
+	defaultStateDirtyColumn   = "dirty"
+)
+
+// stateVersionColumn returns the name of the state table's version column.
+func (m *Migrator) stateVersionColumn() string {
+	if m.StateVersionColumn != "" {
+		return m.StateVersionColumn
+	}
+
+	return defaultStateVersionColumn
+}
+
+// stateDirtyColumn returns the name of the state table's dirty column.
+func (m *Migrator) stateDirtyColumn() string {
+	if m.StateDirtyColumn != "" {
+		return m.StateDirtyColumn
+	}
+
+	return defaultStateDirtyColumn
+}
+
+// stateTable returns the name of the state table, as it appears in SQL.
+func (m *Migrator) stateTable() string {
+	return m.qualify(m.StateTable)
+}
+
+// qualify returns the name of table, which is one of the state table or the
+// tables derived from it, qualified by m.StateSchema if set. When
+// m.StateSchema is set, the schema and table are quoted independently.
+// Otherwise, table is quoted only if m.QuoteStateTable is set, in which case
+// its schema, if any, is quoted independently too.
+func (m *Migrator) qualify(table string) string {
+	if m.StateSchema != "" {
+		return quoteIdent(m.Driver, m.StateSchema) + "." + quoteIdent(m.Driver, table)
+	}
+
+	if !m.QuoteStateTable {
+		return table
+	}
+
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdent(m.Driver, part)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// quoteIdent quotes ident as an identifier, in the syntax of the given driver.
+func quoteIdent(driver, ident string) string {
+	switch driver {
+	case "mysql", "clickhouse":
+		return "`" + strings.ReplaceAll(ident, "`", "``") + "`"
+	case "sqlserver":
+		return "[" + strings.ReplaceAll(ident, "]", "]]") + "]"
+	default:
+		return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+	}
+}
+
+// These are the statements that create the state table, for each driver. They
+// take the name of the state table, then the names of its version and dirty
+// columns. version is 64 bits wide, so that it can hold timestamp versions;
+// state tables created by older versions of sqlcc have a 32-bit version, which
+// getState reads all the same. Where a database has a true boolean type, dirty
+// uses it. applied_at is null until the state is first written, and
+// dirty_migration is null unless the state is dirty.
+const (
+	initSQLMySQL      = `create table %s (%s bigint not null, %s tinyint(1) not null, applied_at datetime(6) null, dirty_migration varchar(255) null)`
+	initSQLPostgres   = `create table %s (%s bigint not null, %s boolean not null, applied_at timestamp null, dirty_migration varchar(255) null)`
+	initSQLSQLite     = `create table %s (%s integer not null, %s boolean not null, applied_at timestamp null, dirty_migration varchar(255) null)`
+	initSQLSQLServer  = `create table %s (%s bigint not null, %s bit not null, applied_at datetime2 null, dirty_migration nvarchar(255) null)`
+	initSQLClickHouse = `create table %s (%s Int64, %s Bool, applied_at Nullable(DateTime64(6)), dirty_migration Nullable(String)) engine = MergeTree order by tuple()`
+)
+
+// dropStateTableSQL drops the state table, so that Init can recreate it.
+const dropStateTableSQL = `drop table %s`
+
+// initSeedSQL inserts the single row of the state table. Like the statements
+// that create the state table, it takes the names of the table, and of its
+// version and dirty columns.
+const initSeedSQL = `insert into %s (%s, %s) values (?, ?)`
+
+// stateTableDDL returns the statements that create and seed the state table,
+// for the given driver and compat. Both statements contain a %s for the name of
+// the state table, followed by one each for the names of its version and dirty
+// columns, and the seed statement takes the initial version and dirty flag as
+// parameters.
+func stateTableDDL(driver string, compat Compat) (string, string) {
+	if compat == CompatGolangMigrate {
+		switch driver {
+		case "sqlite3", "sqlite":
+			return initSQLGolangMigrateSQLite, initSeedSQL
+		case "sqlserver":
+			return initSQLGolangMigrateSQLServer, initSeedSQL
+		default:
+			return initSQLGolangMigrate, initSeedSQL
+		}
+	}
+
+	switch driver {
+	case "mysql":
+		return initSQLMySQL, initSeedSQL
+	case "postgres", "cockroachdb":
+		return initSQLPostgres, initSeedSQL
+	case "sqlite3", "sqlite":
+		return initSQLSQLite, initSeedSQL
+	case "sqlserver":
+		return initSQLSQLServer, initSeedSQL
+	case "clickhouse":
+		// ClickHouse requires every table to have an engine. The state
+		// table is tiny, so it does not need a sort key.
+		return initSQLClickHouse, initSeedSQL
+	default:
+		panic("unreachable")
+	}
+}
+
+// stateTableExists returns whether the state table exists.
+//
+// This must not be called from within a transaction, because on some databases
+// querying a table that does not exist aborts the enclosing transaction. Any
+// error querying the state table is taken to mean that it does not exist; if
+// the error was for some other reason, creating the table will fail too.
+func (m *Migrator) stateTableExists(ctx context.Context) bool {
+	rows, err := m.debugLog().wrap(m.DB).QueryContext(ctx, fmt.Sprintf(stateColumnsSQL, m.stateTable()))
+	if err != nil {
+		return false
+	}
+
+	_ = rows.Close()
+	return true
+}
+
+// initState creates the state table, with a single row at version.
+func (m *Migrator) initState(ctx context.Context, q queryer, version int64) error {
+	createSQL, _ := stateTableDDL(m.Driver, m.Compat)
+	if _, err := q.ExecContext(ctx, fmt.Sprintf(createSQL, m.stateTable(), m.stateVersionColumn(), m.stateDirtyColumn())); err != nil {
+		return fmt.Errorf("create state table: %w", err)
+	}
+
+	return m.seedState(ctx, q, version)
+}
+
+// seedState inserts the single row of the state table, at version. The state
+// table must not already have a row.
+//
+// With CompatGolangMigrate, there is no row at version 0, so then seedState
+// does nothing.
+func (m *Migrator) seedState(ctx context.Context, q queryer, version int64) error {
+	if m.Compat == CompatGolangMigrate && version == 0 {
+		return nil
+	}
+
+	_, seedSQL := stateTableDDL(m.Driver, m.Compat)
+	if _, err := q.ExecContext(ctx, rebind(m.Driver, fmt.Sprintf(seedSQL, m.stateTable(), m.stateVersionColumn(), m.stateDirtyColumn())), version, false); err != nil {
+		return fmt.Errorf("create state table: %w", err)
+	}
+
+	return nil
+}
+
+// ErrNoState is returned when the state table exists, but has no row in it.
+// This happens if the row was deleted by hand, or if creating the state table
+// was interrupted. Init and Reset recover from this by inserting a new row.
+var ErrNoState = errors.New("state table exists but has no row; run sqlcc init or reset")
+
+// ErrDirty is returned, wrapped, when an operation is refused because the state
+// is dirty. See State.
+var ErrDirty = errors.New("state is dirty")
+
+// ErrStateChanged is returned, wrapped, when the state is not what it was when
+// it was read, which means that another process changed it in the meantime,
+// such as by running migrations concurrently.
+var ErrStateChanged = errors.New("state was changed by another process")
+
+// ErrNotInitialized is returned when the state table does not exist, which
+// usually means that Init has not been run.
+var ErrNotInitialized = errors.New("state table does not exist; run sqlcc init")
+
+// State is the contents of the state table.
+type State struct {
+	// Version is the version of the last migration that was run.
+	Version int64
+
+	// Dirty is whether a migration was started, but not completed.
+	Dirty bool
+
+	// AppliedAt is when the state was last written. It is the zero time if the
+	// state has never been written, or if the state table was created by a
+	// version of sqlcc that did not record it.
+	//
+	// AppliedAt is ignored when writing state; the current time is always
+	// used instead.
+	AppliedAt time.Time
+
+	// DirtyMigration is, if Dirty, the name of the migration that was being
+	// run when the state became dirty. It is empty if that is not known, such
+	// as if the state was made dirty with Reset, or if the state table was
+	// created by a version of sqlcc that did not record it.
+	DirtyMigration string
+}
+
+// stateColumnsSQL selects no rows, but its result set still has the state
+// table's columns.
+const stateColumnsSQL = `select * from %s where 1 = 0`
+
+// stateColumns describes the columns of the state table: the names of its
+// version and dirty columns, and which of its optional columns are present.
+// State tables created by older versions of sqlcc lack some of them.
+type stateColumns struct {
+	version        string
+	dirty          string
+	appliedAt      bool
+	dirtyMigration bool
+}
+
+// names returns the names of the state table's columns, in a fixed order.
+func (c stateColumns) names() []string {
+	names := []string{c.version, c.dirty}
+	if c.appliedAt {
+		names = append(names, "applied_at")
+	}
+
+	if c.dirtyMigration {
+		names = append(names, "dirty_migration")
+	}
+
+	return names
+}
+
+// getStateColumns returns the state table's columns.
+//
+// This is checked by inspecting the columns of a query, rather than by trying
+// to use the columns and seeing if that fails, because on some databases a
+// failed statement aborts the enclosing transaction.
+func (m *Migrator) getStateColumns(ctx context.Context, q queryer) (stateColumns, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(stateColumnsSQL, m.stateTable()))
+	if err != nil {
+		if isUndefinedTableError(m.Driver, err) {
+			return stateColumns{}, ErrNotInitialized
+		}
+
+		return stateColumns{}, fmt.Errorf("read state columns from db: %w", err)
+	}
+
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return stateColumns{}, fmt.Errorf("read state columns from db: %w", err)
+	}
+
+	columns := stateColumns{version: m.stateVersionColumn(), dirty: m.stateDirtyColumn()}
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "applied_at":
+			columns.appliedAt = true
+		case "dirty_migration":
+			columns.dirtyMigration = true
+		}
+	}
+
+	return columns, nil
+}
+
+// verifyStateTable checks that the state table, which must exist, has the
+// columns sqlcc expects. The optional columns may be missing, but there must be
+// no others.
+func (m *Migrator) verifyStateTable(ctx context.Context, q queryer) error {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(stateColumnsSQL, m.stateTable()))
+	if err != nil {
+		return fmt.Errorf("read state columns from db: %w", err)
+	}
+
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("read state columns from db: %w", err)
+	}
+
+	// only the version and dirty columns are required; state tables created
+	// by the oldest versions of sqlcc have no others
+	all := stateColumns{version: m.stateVersionColumn(), dirty: m.stateDirtyColumn(), appliedAt: true, dirtyMigration: true}
+	known := map[string]bool{}
+	for _, name := range all.names() {
+		known[strings.ToLower(name)] = false
+	}
+
+	var problems []string
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, ok := known[name]; !ok {
+			problems = append(problems, fmt.Sprintf("unexpected column %q", name))
+		}
+
+		known[name] = true
+	}
+
+	for _, name := range []string{all.version, all.dirty} {
+		if !known[strings.ToLower(name)] {
+			problems = append(problems, fmt.Sprintf("missing column %q", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s exists, but is not a sqlcc state table: %s", m.stateTable(), strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+func (m *Migrator) getState(ctx context.Context, q queryer) (State, error) {
+	columns, err := m.getStateColumns(ctx, q)
+	if err != nil {
+		return State{}, err
+	}
+
+	// SQL Server does not support limit; top is its equivalent
+	query := fmt.Sprintf("select %s from %s limit 1", strings.Join(columns.names(), ", "), m.stateTable())
+	if m.Driver == "sqlserver" {
+		query = fmt.Sprintf("select top 1 %s from %s", strings.Join(columns.names(), ", "), m.stateTable())
+	}
+
+	var s State
+	var appliedAt timestamp
+	var dirtyMigration sql.NullString
+	dest := []any{&s.Version, &s.Dirty}
+	if columns.appliedAt {
+		dest = append(dest, &appliedAt)
+	}
+
+	if columns.dirtyMigration {
+		dest = append(dest, &dirtyMigration)
+	}
+
+	if err := q.QueryRowContext(ctx, query).Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// golang-migrate's state table is empty until a migration is
+			// run
+			if m.Compat == CompatGolangMigrate {
+				return State{}, nil
+			}
+
+			return State{}, ErrNoState
+		}
+
+		return State{}, fmt.Errorf("read state from db: %w", err)
+	}
+
+	s.AppliedAt = time.Time(appliedAt)
+	s.DirtyMigration = dirtyMigration.String
+	return s, nil
+}
+
+// setState writes s to the state table. If the state table has an applied_at
+// column, it is set to the current time, per m.now. If the state table has no
+// row to write to, setState returns ErrNoState, wrapped.
+//
+// ClickHouse does not support synchronous updates, so there the state row is
+// instead replaced entirely.
+func (m *Migrator) setState(ctx context.Context, q queryer, s State) error {
+	return m.writeState(ctx, q, nil, s)
+}
+
+// updateState is like setState, except that it only writes s if the state's
+// version and dirty flag are still those of prev, and otherwise returns
+// ErrStateChanged. This catches another process changing the state since it
+// was read, even on databases that withLock does not lock.
+//
+// The state row is replaced entirely on ClickHouse, so there updateState is
+// the same as setState.
+func (m *Migrator) updateState(ctx context.Context, q queryer, prev, s State) error {
+	return m.writeState(ctx, q, &prev, s)
+}
+
+// writeState writes s to the state table, only if the state is still prev if
+// prev is non-nil.
+func (m *Migrator) writeState(ctx context.Context, q queryer, prev *State, s State) error {
+	columns, err := m.getStateColumns(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	if m.Compat == CompatGolangMigrate {
+		return m.writeGolangMigrateState(ctx, q, columns, prev, s)
+	}
+
+	args := []any{s.Version, s.Dirty}
+	if columns.appliedAt {
+		args = append(args, m.now().UTC())
+	}
+
+	if columns.dirtyMigration {
+		args = append(args, sql.NullString{String: s.DirtyMigration, Valid: s.Dirty && s.DirtyMigration != ""})
+	}
+
+	names := columns.names()
+	if m.Driver == "clickhouse" {
+		if _, err := q.ExecContext(ctx, fmt.Sprintf("truncate table %s", m.stateTable())); err != nil {
+			return fmt.Errorf("write state to db: %w", err)
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+		query := fmt.Sprintf("insert into %s (%s) values (%s)", m.stateTable(), strings.Join(names, ", "), placeholders)
+		if _, err := q.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("write state to db: %w", err)
+		}
+
+		return nil
+	}
+
+	assignments := make([]string, len(names))
+	for i, name := range names {
+		assignments[i] = name + " = ?"
+	}
+
+	query := fmt.Sprintf("update %s set %s", m.stateTable(), strings.Join(assignments, ", "))
+	if prev != nil {
+		query += fmt.Sprintf(" where %s = ? and %s = ?", columns.version, columns.dirty)
+		args = append(args, prev.Version, prev.Dirty)
+	}
+
+	res, err := q.ExecContext(ctx, rebind(m.Driver, query), args...)
+	if err != nil {
+		return fmt.Errorf("write state to db: %w", err)
+	}
+
+	// if the driver cannot say how many rows were updated, there is no
+	// telling whether the state was written, so assume it was
+	n, err := res.RowsAffected()
+	if err != nil {
+		m.debugLog().printf("cannot check that the state was written: %v", err)
+		return nil
+	}
+
+	if n > 0 {
+		return nil
+	}
+
+	// Nothing was updated. Either there is no state row, or the state is no
+	// longer prev, or, on MySQL, which counts only rows whose values changed,
+	// the state was already s. Reading the state tells these apart.
+	current, err := m.getState(ctx, q)
+	if err != nil {
+		return fmt.Errorf("write state to db: %w", err)
+	}
+
+	if prev != nil && (current.Version != prev.Version || current.Dirty != prev.Dirty) {
+		return fmt.Errorf("%w: expected version %d (dirty: %t)", ErrStateChanged, prev.Version, prev.Dirty)
+	}
+
+	return nil
+}
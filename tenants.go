@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ucarion/sqlcc/migrator"
+)
+
+// tenants returns the tenants given in --tenants, which is a comma-separated
+// list.
+func (a migrateArgs) tenants() []string {
+	var tenants []string
+	for _, tenant := range strings.Split(a.Tenants, ",") {
+		if tenant = strings.TrimSpace(tenant); tenant != "" {
+			tenants = append(tenants, tenant)
+		}
+	}
+
+	return tenants
+}
+
+// validateTenants validates --tenants and the arguments that may not be
+// combined with it.
+func (a migrateArgs) validateTenants() error {
+	if a.Tenants == "" {
+		if a.FailFast {
+			return usageErrorf("--fail-fast requires --tenants")
+		}
+
+		return nil
+	}
+
+	switch {
+	case a.From.set:
+		return usageErrorf("--tenants and --from are mutually exclusive")
+	case a.NoState:
+		return usageErrorf("--tenants and --no-state are mutually exclusive")
+	case a.PrintPlan:
+		return usageErrorf("--tenants and --print-plan are mutually exclusive")
+	case a.Format == "json":
+		return usageErrorf("--tenants and --format json are mutually exclusive")
+	case a.RootArgs.StateSchema != "":
+		return usageErrorf("--tenants and --state-schema are mutually exclusive")
+	case a.RootArgs.SearchPath != "":
+		return usageErrorf("--tenants and --search-path are mutually exclusive")
+	}
+
+	switch a.RootArgs.Driver {
+	case "postgres", "cockroachdb", "mysql":
+		// noop
+	default:
+		return usageErrorf("invalid --tenants: tenants are schemas, which %s does not support", a.RootArgs.Driver)
+	}
+
+	tenants := a.tenants()
+	if len(tenants) == 0 {
+		return usageErrorf("invalid --tenants: must contain at least one tenant")
+	}
+
+	for _, tenant := range tenants {
+		if err := migrator.ValidateStateSchema(tenant, a.RootArgs.StateTable); err != nil {
+			return usageErrorf("invalid --tenants: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// tenantsError is returned when migrating one or more tenants failed. Each
+// tenant's error has already been output; tenantsError only summarizes them.
+type tenantsError struct {
+	failed []string
+	total  int
+
+	// err is the error of the first tenant that failed, which decides the
+	// exit code
+	err error
+}
+
+func (e tenantsError) Error() string {
+	return fmt.Sprintf("%d of %d tenant(s) failed: %s", len(e.failed), e.total, strings.Join(e.failed, ", "))
+}
+
+func (e tenantsError) Unwrap() error {
+	return e.err
+}
+
+// migrateTenants migrates each of --tenants in turn, using m's database. Each
+// tenant is a schema, which holds the tenant's state table, and which the
+// tenant's migrations are run with as their search path.
+//
+// Unless --fail-fast was provided, a tenant failing does not stop the tenants
+// after it from being migrated.
+func migrateTenants(ctx context.Context, m *migrator.Migrator, args migrateArgs, opts migrator.MigrateOptions) error {
+	tenants := args.tenants()
+
+	var errs tenantsError
+	errs.total = len(tenants)
+	for _, tenant := range tenants {
+		_, _ = fmt.Fprintf(os.Stderr, "migrating tenant %q\n", tenant)
+
+		tm := *m
+		tm.StateSchema = tenant
+		tm.SearchPath = []string{tenant}
+
+		results, err := tm.Migrate(ctx, opts)
+		if err == nil || migrationsFailed(err) {
+			printSummary(args, results)
+		}
+
+		if err == nil && args.Check && len(results) > 0 {
+			err = fmt.Errorf("%w: %d migration(s) not yet applied", errPending, len(results))
+		}
+
+		if err == nil {
+			continue
+		}
+
+		err = fmt.Errorf("tenant %q: %w", tenant, err)
+		if args.FailFast {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(os.Stderr, "%s migrate: %v\n", os.Args[0], redactedError{err: err})
+		if errs.err == nil {
+			errs.err = err
+		}
+
+		errs.failed = append(errs.failed, tenant)
+	}
+
+	if len(errs.failed) > 0 {
+		return errs
+	}
+
+	return nil
+}
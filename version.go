@@ -0,0 +1,21 @@
+package main
+
+import "strconv"
+
+// optionalVersion is a migration version that can be parsed from command-line
+// arguments, and that records whether it was provided at all, so that an
+// explicit version of 0 can be told apart from no version.
+type optionalVersion struct {
+	set     bool
+	version uint64
+}
+
+func (v *optionalVersion) UnmarshalText(text []byte) error {
+	parsed, err := strconv.ParseUint(string(text), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*v = optionalVersion{set: true, version: parsed}
+	return nil
+}